@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/alessio/shellescape"
+)
+
+// maxOscLength caps how much of an OSC sequence we hold on to while finding out whether it's a notification
+const maxOscLength = 4096
+
+// the OSC 9 sequences of ConEmu and Windows Terminal, like progress reports - unlike the OSC 9 of iTerm2, these
+// aren't notifications
+var conEmuOsc9 = regexp.MustCompile(`^9;\d+(;|$)`)
+
+type alertParserState int
+
+const (
+	alertGround alertParserState = iota
+	alertEscape
+	alertOsc
+	alertOscEscape
+)
+
+// alertForwarder passes the bell and OSC 9 and 777 notifications written by a command in the background straight on
+// to the terminal with --forward-alerts, instead of them only coming up once its output gets shown
+type alertForwarder struct {
+	tag string
+
+	// separate parser state for every fd, like in terminalModes
+	parsers [3]alertParser
+}
+
+type alertParser struct {
+	state alertParserState
+	held  []byte // the escape sequence read so far - not stored until we know it isn't a notification
+}
+
+func newAlertForwarder(job *Job) *alertForwarder {
+	// the arguments tell commands apart better than the command itself, most of which they all share
+	described := job.arguments
+	if len(described) == 0 {
+		described = job.command
+	}
+	tag := fmt.Sprintf("#%d %s", job.seq, abbreviate(shellescape.QuoteCommand(described), 60))
+	// ';' separates the title from the body in OSC 777
+	tag = strings.Map(func(r rune) rune {
+		if r < ' ' || r == ';' {
+			return ' '
+		}
+		return r
+	}, tag)
+	return &alertForwarder{tag: tag}
+}
+
+// filter forwards the alerts found in data, returning the rest of it to be stored
+func (forwarder *alertForwarder) filter(fd int, data []byte) []byte {
+	parser := &forwarder.parsers[fd]
+	if parser.state == alertGround && bytes.IndexByte(data, '\a') == -1 && bytes.IndexByte(data, '\x1b') == -1 {
+		return data
+	}
+
+	kept := make([]byte, 0, len(data))
+	for _, b := range data {
+		if parser.state == alertGround {
+			switch b {
+			case '\a':
+				forwarder.forward("\a")
+			case '\x1b':
+				parser.state = alertEscape
+				parser.held = append(parser.held[:0], b)
+			default:
+				kept = append(kept, b)
+			}
+			continue
+		}
+
+		parser.held = append(parser.held, b)
+		switch parser.state {
+		case alertEscape:
+			if b == ']' {
+				parser.state = alertOsc
+				continue
+			}
+		case alertOsc:
+			switch {
+			case b == '\a':
+				if forwarder.notify(parser.held[2 : len(parser.held)-1]) {
+					parser.state = alertGround
+					continue
+				}
+			case b == '\x1b':
+				parser.state = alertOscEscape
+				continue
+			case len(parser.held) < maxOscLength:
+				continue
+			}
+		case alertOscEscape:
+			if b == '\\' && forwarder.notify(parser.held[2:len(parser.held)-2]) {
+				parser.state = alertGround
+				continue
+			}
+		}
+
+		// not a notification, so it stays where it was
+		kept = append(kept, parser.held...)
+		parser.state = alertGround
+	}
+	return kept
+}
+
+// notify forwards an OSC sequence if it's a notification, tagged with which command it comes from
+func (forwarder *alertForwarder) notify(osc []byte) bool {
+	switch {
+	case bytes.HasPrefix(osc, []byte("9;")) && !conEmuOsc9.Match(osc):
+		forwarder.forward(fmt.Sprintf("\x1b]9;%s: %s\a", forwarder.tag, osc[len("9;"):]))
+	case bytes.HasPrefix(osc, []byte("777;notify;")):
+		forwarder.forward(fmt.Sprintf("\x1b]777;notify;%s: %s\a", forwarder.tag, osc[len("777;notify;"):]))
+	default:
+		return false
+	}
+	return true
+}
+
+func (forwarder *alertForwarder) forward(alert string) {
+	debugf("term", "forwarding %q", alert)
+	writeSideband(alert)
+}
+
+// release stores what's been held back while looking for a notification, before a command's output gets shown - the
+// rest of a sequence is then written out directly, without going through filter
+func (forwarder *alertForwarder) release(out *Output) {
+	for fd := range forwarder.parsers {
+		parser := &forwarder.parsers[fd]
+		if parser.state != alertGround {
+			out.appendChunk(byte(fd), parser.held)
+			parser.state = alertGround
+		}
+	}
+}
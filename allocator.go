@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+	"modernc.org/memory"
+)
+
+// bufferBackend is where the output of background children gets stored, selected with --buffer-backend
+type bufferBackend interface {
+	Calloc(size int) ([]byte, error)
+	Realloc(buf []byte, size int) ([]byte, error)
+	Free(buf []byte) error
+	Close() error
+}
+
+var bufferBackends = map[string]func() (bufferBackend, error){
+	"memory":   func() (bufferBackend, error) { return &memory.Allocator{}, nil },
+	"memfd":    newMemfdBackend,
+	"tempfile": newTempFileBackend,
+}
+
+func newBufferBackend() bufferBackend {
+	backend, err := bufferBackends[*flBufferBackend]()
+	if err != nil {
+		// in the middle of running, we don't have a better option than to fall back on plain memory
+		_, _ = fmt.Fprintf(os.Stderr, "%s: Warning: could not set up the %s buffer backend, using memory instead: %v\n",
+			os.Args[0], *flBufferBackend, err)
+		return &memory.Allocator{}
+	}
+	return backend
+}
+
+// fileBackend keeps the buffer in a mapping of a file which isn't visible in the filesystem. The output of every
+// child is kept in a single growing buffer, so only one buffer can be allocated from it at a time.
+type fileBackend struct {
+	file   *os.File
+	mapped []byte
+}
+
+var errOneBufferOnly = errors.New("a file buffer backend can only hold one buffer at a time")
+
+func newTempFileBackend() (bufferBackend, error) {
+	file, err := os.CreateTemp("", "gparallel-buffer-*")
+	if err != nil {
+		return nil, err
+	}
+
+	// it's still going to be there for as long as we have it open
+	if err := os.Remove(file.Name()); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &fileBackend{file: file}, nil
+}
+
+func (backend *fileBackend) Calloc(size int) ([]byte, error) {
+	if backend.mapped != nil {
+		return nil, errOneBufferOnly
+	}
+	return backend.resize(size)
+}
+
+func (backend *fileBackend) Realloc(buf []byte, size int) ([]byte, error) {
+	if buf == nil {
+		return backend.Calloc(size)
+	}
+	if backend.mapped == nil || &buf[:1][0] != &backend.mapped[:1][0] {
+		return nil, errOneBufferOnly
+	}
+
+	if err := unix.Munmap(backend.mapped); err != nil {
+		return nil, err
+	}
+	backend.mapped = nil
+
+	return backend.resize(size)
+}
+
+func (backend *fileBackend) resize(size int) ([]byte, error) {
+	// growing the file fills it with zeroes, keeping what's been there already
+	if err := backend.file.Truncate(int64(size)); err != nil {
+		return nil, err
+	}
+
+	mapped, err := unix.Mmap(int(backend.file.Fd()), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	backend.mapped = mapped
+	return mapped, nil
+}
+
+func (backend *fileBackend) Free(buf []byte) error {
+	if buf == nil || backend.mapped == nil {
+		return nil
+	}
+
+	if err := unix.Munmap(backend.mapped); err != nil {
+		return err
+	}
+	backend.mapped = nil
+
+	return backend.file.Truncate(0)
+}
+
+func (backend *fileBackend) Close() error {
+	if backend.mapped != nil {
+		_ = unix.Munmap(backend.mapped)
+		backend.mapped = nil
+	}
+	return backend.file.Close()
+}
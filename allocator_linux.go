@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func newMemfdBackend() (bufferBackend, error) {
+	fd, err := unix.MemfdCreate("gparallel-buffer", unix.MFD_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &fileBackend{file: os.NewFile(uintptr(fd), "memfd:gparallel-buffer")}, nil
+}
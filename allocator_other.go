@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func newMemfdBackend() (bufferBackend, error) {
+	return nil, errors.New("memfd_create is only available on Linux")
+}
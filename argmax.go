@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// commandLineSize approximates how much of the space the OS reserves for exec arguments a command line would take:
+// every string with its NUL terminator, plus a pointer to it
+func commandLineSize(argv []string, env []string) (size int) {
+	for _, s := range argv {
+		size += len(s) + 1 + int(unsafe.Sizeof(uintptr(0)))
+	}
+	for _, s := range env {
+		size += len(s) + 1 + int(unsafe.Sizeof(uintptr(0)))
+	}
+	return size
+}
+
+// checkCommandLineSize tells whether the OS would refuse to start a command with E2BIG
+func checkCommandLineSize(argv []string, env []string) error {
+	for _, arg := range argv {
+		if maxArg := maxSingleArgument(); maxArg > 0 && len(arg)+1 > maxArg {
+			return fmt.Errorf("a single argument of the command is %d bytes long, but the system limit is %d", len(arg), maxArg)
+		}
+	}
+
+	// a limit of 0 is one we don't know
+	if size, limit := commandLineSize(argv, env), argMax(); limit > 0 && size > limit {
+		return fmt.Errorf("the command line together with the environment takes %d bytes, but the system limit is %d", size, limit)
+	}
+
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+func argMax() int {
+	limit, err := unix.SysctlUint32("kern.argmax")
+	if err != nil {
+		return 256 * 1024
+	}
+	return int(limit)
+}
+
+// maxSingleArgument returns 0, as there is no limit on a single argument other than argMax
+func maxSingleArgument() int {
+	return 0
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// argMax mirrors how Linux computes the space for exec arguments and the environment: a quarter of the stack
+// limit, capped to 3/4 of the default 8MiB stack, but at least 128KiB
+func argMax() int {
+	const (
+		defaultStackLimit = 8 * 1024 * 1024
+		minArgMax         = 128 * 1024
+	)
+
+	limit := defaultStackLimit / 4 * 3
+
+	var stackLimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_STACK, &stackLimit); err == nil && stackLimit.Cur/4 < uint64(limit) {
+		limit = int(stackLimit.Cur / 4)
+	}
+
+	return max(limit, minArgMax)
+}
+
+// maxSingleArgument is MAX_ARG_STRLEN - 32 pages
+func maxSingleArgument() int {
+	return 32 * os.Getpagesize()
+}
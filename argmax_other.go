@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !solaris
+
+package main
+
+// argMax returns 0, as we don't know how to find out the actual limit - commands then just get started, and fail
+// with E2BIG if they turn out to be too long
+func argMax() int {
+	return 0
+}
+
+func maxSingleArgument() int {
+	return 0
+}
@@ -0,0 +1,19 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// _SC_ARG_MAX of Solaris and illumos, which golang.org/x/sys doesn't define
+const scArgMax = 1
+
+func argMax() int {
+	limit, err := unix.Sysconf(scArgMax)
+	if err != nil || limit <= 0 {
+		return 0
+	}
+	return int(limit)
+}
+
+// maxSingleArgument returns 0, as there is no limit on a single argument other than argMax
+func maxSingleArgument() int {
+	return 0
+}
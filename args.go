@@ -2,15 +2,21 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"runtime"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/fatih/color"
 	memoryStats "github.com/pbnjay/memory"
 	flag "github.com/spf13/pflag"
 	"golang.org/x/exp/slices"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
 )
 
 type Args struct {
@@ -20,25 +26,124 @@ type Args struct {
 }
 
 var (
+	flAfter                  = flag.String("after", "", "A shell `command` to run after every command finishes, in the same directory and environment,\nwith its exit code in $GPARALLEL_EXIT_CODE. The replacement string is replaced with the\narguments quoted for the shell. See --on-hook-failure.")
+	flArgFile                = flag.StringP("arg-file", "a", "", "Get input from the lines of a `file`, like -s does from stdin.")
+	flBatteryJ               = flag.Int("battery-j", 0, "Run at most `n` commands at once while on battery, or while the CPU is hotter than\n--throttle-temp. Checked again every 10 seconds. (0 means no such limit)")
+	flBefore                 = flag.String("before", "", "A shell `command` to run before every command starts, like --after.")
+	flBufferBackend          = flag.String("buffer-backend", "memory", "Where to keep the output of commands running in the background: 'memory', 'memfd'\n(Linux only, a file living in memory) or 'tempfile' (an unlinked file in $TMPDIR,\nfor outputs too large to fit in memory).")
+	flCache                  = flag.String("cache", "", "Keep the output of commands that succeed in a `directory`, and show it again instead of running\na command that's the same as one kept there - in its arguments, --wd, --env and --stdin-file.")
+	flCacheFiles             = flag.Bool("cache-files", false, "Also tell commands apart in the --cache by the contents of their arguments that are files.")
+	flCacheMaxAge            = flag.Duration("cache-max-age", 0, "Run commands again if what's in the --cache for them is older than this. (0 means never)")
+	flCacheRefresh           = flag.Bool("cache-refresh", false, "Run every command again even if it's in the --cache, replacing what's kept there.")
+	flCanary                 = flag.Int("canary", 0, "Start only the first `n` commands, and the rest only once all of those have succeeded - not\nstarting thousands of commands when all of them are going to fail anyway.")
+	flChecksum               = flag.Bool("checksum", false, "After every command, print the SHA-256 of its stdout to stderr, in the format of sha256sum.")
+	flCleanEnv               = flag.Bool("clean-env", false, "Don't pass our environment on to commands, except for HOME, LANG, LOGNAME, PATH, SHELL,\nTERM, TMPDIR, TZ, USER and --keep-env. --env still adds to it.")
+	flColor                  = flag.String("color", "auto", "Whether to color what gparallel prints itself: 'auto' (when stderr is a terminal, unless\nNO_COLOR is set or CLICOLOR_FORCE forces it), 'always' or 'never'.")
+	flColumns                = flag.Int("columns", 0, "The width of the terminal commands get, instead of the width of ours - like 80 for output not\ndepending on where it's run. Also sets $COLUMNS. (0 means following our terminal)")
+	flCombine                = flag.String("combine", "", "Take arguments both after \":::\" and from lines of -s or --arg-file (without it, \":::\" is just\na part of the command then): 'concat' runs the \":::\" ones first and then the lines, 'zip'\nruns every argument together with the line at the same position, and 'product' runs every\nargument together with every line.")
+	flControlKey             = flag.String("control-key", "^]", "With stdin forwarded to the command in the foreground, this `key` followed by k terminates\nthat command, followed by s skips to the next one (showing the rest of its output at the\nend), followed by p pauses or resumes starting new commands (like SIGUSR2 does), and\npressed twice passes it on. An empty value turns it off.")
+	flCsv                    = flag.Bool("csv", false, "Read -s or --arg-file input as CSV, with the fields of every record becoming the arguments\nof one command.")
+	flDeadline               = flag.String("deadline", "", "Stop starting commands and terminate the running ones (see --signal) once this `time`\npasses, still showing their output, and exit with 124. Either a duration, or a time like\n'15:04' or '2006-01-02 15:04'.")
+	flDebug                  = flag.String("debug", "", "Log what gparallel itself is doing, for debugging it: about the given comma-separated\n`categories` out of memory, pty, scheduler and term (like --debug=pty,term), or about all\nof them with just --debug.")
+	flDebugFile              = flag.String("debug-file", "", "Append --debug messages to a `file` instead of writing them to stderr.")
+	flDebugMemory            = flag.Duration("debug-memory", 0, "Log how much output is buffered, by which commands and where, every `interval`.\nUseful for tuning --max-mem and -P.")
+	flDedup                  = flag.Bool("dedup", false, "Skip commands that are exactly the same as one that has already been started, saying how\nmany have been skipped at the end of input. $GPARALLEL_TOTAL doesn't get set then.")
+	flDiffOutputs            = flag.Bool("diff-outputs", false, "Run every command once for every --variant, and instead of their outputs show whether\nthey've differed, with a unified diff of stdout when they have.")
+	flDiskFree               = flag.StringArray("diskfree", nil, "Don't start another command while the filesystem of `PATH:SIZE` has less than SIZE free,\nlike /tmp:20GiB. Can be specified multiple times.")
+	flDryRun                 = flag.Bool("dry-run", false, "Print the commands that would be run instead of running them.")
+	flEnv                    = flag.StringArray("env", nil, "Set an environment variable for every command, as `KEY=VALUE`. The value can contain the\nreplacement string. Can be specified multiple times.\n(GPARALLEL_SEQ, GPARALLEL_SLOT and GPARALLEL_TOTAL are always set)")
 	flExecuteAndFlushTty     = flag.Bool("_execute-and-flush-tty", false, "Execute a given command and flush attached ttys afterwards. Used internally by gparallel.")
+	flEstimate               = flag.Int("estimate", 0, "Start only the first `n` commands, and once they've finished, print how long running the rest\nis going to take before starting them. Reads all input first.")
+	flExplain                = flag.Bool("explain", false, "Describe every command that would be run - its arguments, environment, directory and how\nits output would be handled - instead of running them.")
+	flFailIfEmpty            = flag.Bool("fail-if-empty", false, "Without any arguments to run the command with, say so and exit with 122 instead of 0.")
+	flFixCursor              = flag.Bool("fix-cursor", true, "After every command, put back the terminal modes it has changed - like a hidden cursor or\ncolors - and start a new line if it hasn't ended its own. --fix-cursor=false passes output\non byte for byte.")
+	flFlock                  = flag.String("flock", "", "Take an flock(2) on a `file` for as long as a command runs, waiting for anything else holding\nit - like cron jobs touching the same files. Can contain the replacement string, and is\nrelative to --wd. A command waiting for it holds back starting the ones after it.")
+	flFlockSkip              = flag.Bool("flock-skip", false, "Skip a command whose --flock is held by something else, instead of waiting for it.")
+	flForwardAlerts          = flag.Bool("forward-alerts", false, "Ring the bell and show OSC 9 and OSC 777 notifications (like \"build finished\") of commands\nin the background as soon as they come, tagged with the command, instead of once their output\ngets shown.")
+	flForwardStdin           = flag.Bool("forward-stdin", true, "Pass keys typed into the terminal to the command currently shown in the foreground.\n(only when both stdin and stdout are terminals)")
+	flFreeOSMemoryEvery      = flag.String("free-os-memory-every", "64MiB", "Ask Go to return unused memory to the OS after this much saved output has been written out.\n(0 means after every command)")
 	flFromStdin              = flag.BoolP("from-stdin", "s", false, "Get input from stdin.")
+	flGnuCompat              = flag.Bool("gnu-compat", false, "Accept the common options of GNU parallel (-j, -k, --halt, --lb, -q...) before the command,\nand refuse the ones gparallel can't support.")
+	flGroup                  = flag.String("group", "", "Run commands with a `group` (a name or a number) as their group. Only when running as root.")
+	flGroupBy                = flag.String("group-by", "", "Show the outputs of commands sharing a `key` one after another, under a single header,\nin the order of keys. The key is the argument with the given number, or what a regular\nexpression matches in the arguments (its first group, if it has one). Reads all input first.")
+	flHaltOnSuccess          = flag.Bool("halt-on-success", false, "Stop once any command succeeds: terminate the others, and exit with 0 after showing its\noutput - like when trying mirrors until one works. Failures don't stop anything then.")
 	flHelp                   = flag.BoolP("help", "h", false, "Show this help message.")
+	flInputBuffer            = flag.Int("input-buffer", 0, "Read up to `n` commands ahead of the ones started so far, so that a slow -s or --arg-file\ninput doesn't hold them back - but never more than that. (0 means reading the next one only\nonce it can be started)")
+	flJobserver              = flag.Bool("jobserver", true, "When run by make -j, take a token from the jobserver of make for every command, so that\nmake and gparallel together don't run more than -j jobs.")
+	flJson                   = flag.Bool("json", false, "Make --explain print a JSON list of commands.")
+	flKeepEnv                = flag.StringArray("keep-env", nil, "The `name` of another environment variable to keep with --clean-env. Can be specified\nmultiple times.")
+	flKeepFailedScratch      = flag.Bool("keep-failed-scratch", false, "Keep the --scratch directories of commands that have failed.")
 	flKeepGoingOnError       = flag.Bool("keep-going-on-error", false, "Don't exit on error, keep going.")
+	flKillAfter              = flag.Duration("kill-after", 0, "How long to wait after sending --signal to a command before killing it with SIGKILL.\n(0 means never escalate)")
+	flLinger                 = flag.Duration("linger", 0, "How long to keep collecting the output of a command after it exits, if processes it has left\nbehind still hold its stdout/stderr open. (0 means waiting for them indefinitely)")
+	flListen                 = flag.String("listen", "", "Accept more commands and arguments sent with --submit over a unix socket at `path`, until\nan empty --submit tells there's no more to come.")
+	flMaxArgs                = flag.IntP("max-args", "n", 1, "Pass up to this many arguments to every command (0 means as many as fit in a command line).\nA replacement string standing alone as a word expands to all of them as separate words.")
+	flMaxLineLen             = flag.String("max-line-len", "1MiB", "The longest `size` of a line (or with --null, a record) of -s or --arg-file input, failing\nwith an error on a longer one instead of trying to hold all of it. (0 means no limit)")
 	flMaxMemory              = flag.String("max-mem", "5%", "How much system `memory` can be used for storing command outputs before we start blocking.\nSet to 'inf' to disable the limit.")
 	flMaxProcesses           = flag.IntP("max-concurrent", "P", max(runtime.NumCPU(), 1), "How many concurrent `children` to execute at once at maximum.\n(default based on the amount of cores)")
 	flMaxProcessesUpperLimit = flag.Int("max-concurrent-upper-limit", max(runtime.NumCPU(), 1), "The upper limit of maximum processes when inferring them from the number of CPUs.")
+	flMaxRecords             = flag.Int("max-records", 0, "Fail with an error if -s or --arg-file input has more than `n` lines (or with --null, records).\n(0 means no limit)")
+	flNoRunIfEmpty           = flag.Bool("no-run-if-empty", false, "Don't run anything if there are no arguments, and exit with 0 - which is the default,\nundoing an earlier --run-if-empty.")
+	flNoTty                  = flag.Bool("no-tty", false, "Capture the output of commands through pipes instead of ptys, even if stdout is a terminal.\nUseful when ptys are scarce or unavailable, and the commands don't need a terminal.")
+	flNotify                 = flag.StringArray("notify", nil, "When everything has finished, ring the terminal 'bell', show a 'desktop' notification, or\nPOST a JSON summary to a webhook `URL`. Can be specified multiple times.")
+	flNull                   = flag.BoolP("null", "0", false, "Arguments read with -s or --arg-file are terminated by NUL characters instead of newlines.")
+	flOnHookFailure          = flag.String("on-hook-failure", "fail", "What to do when a --before or --after hook fails: 'fail' the command (a failed --before\nhook means the command doesn't run at all), or just 'warn'.")
+	flOnStall                = flag.String("on-stall", "terminate", "What to do with a command exceeding --stall-timeout: 'terminate' it, or just 'warn'.")
+	flPersist                = flag.String("persist", "", "Keep everything submitted to --listen in a `file`, to run whatever hasn't finished\nyet again when started with the same file.")
+	flPprof                  = flag.String("pprof", "", "Serve net/http/pprof profiles of this gparallel on `address` (like localhost:6060) while it runs.")
 	flQueueCommandAncestor   = flag.String("queue-command-ancestor", "", "Queue a command for a specific ancestor process with a `name` to later execute with --wait.")
 	flQueueCommandParent     = flag.Bool("queue-command", false, "Queue a command for parent of gparellel to later execute with --wait.")
 	flQueueCommandPid        = flag.Int("queue-command-pid", -1, "Queue a command for a specific ancestor `pid` to let it later execute it with --wait.")
 	flQueueWait              = flag.Bool("wait", false, "Execute and wait for commands queued using --queue-*.")
+	flRace                   = flag.Bool("race", false, "Show only the output of the command that writes anything (or finishes) first, terminating\nthe others and throwing away what they've written - like when asking replicas of a service.")
+	flRawBuffer              = flag.String("raw-buffer", "auto", "Whether to store output without looking for the escape sequences changing terminal modes\n(which get reverted after every command): 'auto' looks only where there's an escape byte,\n'always' never looks, leaving modes and the cursor as commands have left them, and 'never'\nchecks every byte.")
 	flRecursiveProcessLimit  = flag.Bool("recursive-max-concurrent", true, "Whether to apply the one -P children limit to all gparallel subprocesses as well as a shared\nresource.")
+	flRlimit                 = flag.String("rlimit", "", "Resource `limits` for every command, like cpu=300,as=4G,nofile=1024 - set as both the soft\nand the hard limit. Resources: as, core, cpu (seconds), data, fsize, nofile and stack, or\n'unlimited' as the value. (Linux and macOS only)")
+	flRlimitExec             = flag.String("_rlimit", "", "Set resource limits and execute a given command. Used internally by gparallel.")
+	flRows                   = flag.Int("rows", 0, "The height of the terminal commands get, like --columns. Also sets $LINES.")
+	flRunIfEmpty             = flag.Bool("run-if-empty", false, "Run the command once without any arguments if there are none, instead of running nothing.")
+	flSignal                 = flag.String("signal", "TERM", "The `signal` sent to commands that should stop, after a failure or on a repeated ^C.")
+	flShutdownGrace          = flag.Duration("shutdown-grace", 10*time.Second, "How long to let commands exit after passing SIGTERM or SIGHUP onto them, before\nkilling them.")
+	flScratch                = flag.Bool("scratch", false, "Give every command a new temporary directory of its own, in $GPARALLEL_TMPDIR and in place\nof \"{tmp}\" in the command, removed after the command ends.")
+	flSetTitle               = flag.Bool("set-title", false, "Show the progress in the title of the terminal - how many commands have finished, failed,\nand which one is being shown - putting the title back on exit.")
+	flShard                  = flag.String("shard", "", "Only run the jobs of shard `K/N` of the input - every N-th one, starting from the K-th.\nStarting N gparallels with the same input, each with a different K, runs all of them once.")
+	flShardBy                = flag.String("shard-by", "", "Run commands whose `key` is the same one after another, in the order of the input, while\nothers run in parallel. The key can contain the replacement string, like --shard-by {}.\nA command waiting for its turn holds back starting the ones after it.")
 	flShowQueue              = flag.Bool("show-queue", false, "Show every queued command for every process - useful for debugging missing --wait calls.")
+	flSkipIfExists           = flag.String("skip-if-exists", "", "Skip a command if a marker `file` exists, and create it once the command succeeds - like\n--skip-if-exists {}.done, making a run over files resumable. Relative to --wd.")
 	flSlurpStdin             = flag.Bool("slurp-stdin", false, "Read all available stdin and pass it onto the command - only works in the --queue-command-* mode.\n(as otherwise it would send everything to the first command).")
-	flTemplate               = flag.StringP("replacement", "I", "{}", "The `replacement` string.")
-	flVerbose                = flag.BoolP("verbose", "v", false, "Print the full command line before each execution.")
+	flSortByCost             = flag.String("sort-by-cost", "", "Start the most costly commands first, by the number a shell `command` prints for each of them.\nThe replacement string is replaced with the arguments quoted for the shell. Reads all input first.")
+	flSortBySize             = flag.Bool("sort-by-size", false, "Start the commands whose arguments are the largest files first, so that the biggest ones\ndon't end up finishing last. Reads all input first.")
+	flSpawnAhead             = flag.Int("spawn-ahead", 0, "Start a command only while fewer than `n` have been started since the oldest one still\nrunning, including it - so that when one fails, not many others have been started just to be\nterminated. Trades some parallelism for that. (0 means no such limit)")
+	flStallTimeout           = flag.Duration("stall-timeout", 0, "Consider a command stalled if it doesn't write anything for this long, see --on-stall.\n(0 disables stall detection)")
+	flStderr                 = flag.String("stderr", "", "Write the stderr of every command to a `file` instead of showing it, like --stdout.")
+	flStdinFile              = flag.String("stdin-file", "", "Give every command a `file` as its stdin, which can contain the replacement string.\nRelative to --wd. A command fails if its file doesn't exist.")
+	flStdout                 = flag.String("stdout", "", "Write the stdout of every command to a `file` instead of showing it. The file name can\ncontain the replacement string, and is relative to --wd. Commands get pipes instead of ptys.")
+	flSubmit                 = flag.String("submit", "", "Send the command, or arguments after \":::\", to a gparallel started with --listen at `path`.\nWith nothing to send, tell it not to expect any more.")
+	flSummary                = flag.Bool("summary", false, "Print a summary of the run to stderr at the end, including the jobs that used the most\nCPU time, memory and block IO.")
+	flTee                    = flag.Bool("tee", false, "Pass all of stdin to every command. What commands started later haven't got yet is kept\nin memory.")
+	flTemplates              = flag.StringArrayP("replacement", "I", []string{"{}"}, "The `replacement` string. Given more than once, like -I {in} -I {out}, every command gets\nas many arguments, each of them replacing its own string.")
+	flTerm                   = flag.String("term", "", "The $TERM of commands, like 'dumb' to make them skip colors and cursor movement, instead of\nour own one.")
+	flThrottleTemp           = flag.Int("throttle-temp", 0, "The CPU temperature in `degrees` Celsius from which --battery-j applies as well.\n(Linux only, 0 means never)")
+	flTmux                   = flag.Bool("tmux", false, "Run every command in a window of its own in a new tmux session, which can be attached to\nto watch them live, instead of capturing their output.")
+	flTmuxJob                = flag.String("_tmux-job", "", "Run a given command in a new window of a tmux session and wait for it. Used internally by gparallel.")
+	flTmuxWindow             = flag.String("_tmux-window", "", "Run a given command inside of a tmux window. Used internally by gparallel.")
+	flTrace                  = flag.String("trace", "", "Write a Go runtime execution trace of the whole run to `file`.")
+	flUnshare                = flag.String("unshare", "", "Run every command in new Linux `namespaces` of its own, from: net (no network), pid, mount,\nipc and uts, like --unshare net,pid.")
+	flUnshareInit            = flag.String("_unshare-init", "", "Set up new namespaces and run a given command as their first process. Used internally by gparallel.")
+	flUser                   = flag.String("user", "", "Run commands as a `user` (a name or a number), with its groups and its HOME, USER and\nLOGNAME. Only when running as root.")
+	flVariant                = flag.StringArray("variant", nil, "A `value` replacing \"{variant}\" in the command for --diff-outputs. Has to be given at least\ntwice, the first one being what the others are compared with.")
+	flVerbose                = flag.CountP("verbose", "v", "Print the full command line before each execution. Given twice also log when every command\nstarts and finishes, given three times also log how commands wait for slots and memory.")
 	flVersion                = flag.Bool("version", false, "Show the program version.")
-
-	parsedFlMaxMemory int64
+	flWatch                  = flag.Duration("watch", 0, "Run everything again every `interval` (and as soon as --arg-file changes), clearing the\nscreen before every round and listing the commands whose exit codes have changed.")
+	flWorkDir                = flag.String("wd", "", "Run every command in the `directory`, which can contain the replacement string.\nA command fails if its directory doesn't exist.")
+	flWorkDirCreate          = flag.Bool("wd-create", false, "Create the --wd directory of a command if it doesn't exist.")
+	flXargsCompat            = flag.Bool("xargs-compat", false, "Accept the options of xargs (-I, -P, -n, -L, -0, -t, -r, -a) before the command, and split\nstdin into arguments like xargs does.")
+
+	parsedFlFreeOSMemoryEvery int64
+	parsedFlMaxLineLen        int64
+	parsedFlMaxMemory         int64
+	parsedFlSignal            syscall.Signal
+	parsedFlTemplates         []string
 )
 
 func showVersion() {
@@ -87,10 +192,12 @@ func countTrue(values ...bool) (i int) {
 func usage() {
 	_, _ = fmt.Fprintf(os.Stderr, "Usage: %s    [-v] [-P proc] [-I replacement] command [arguments] ::: arguments\n", os.Args[0])
 	_, _ = fmt.Fprintf(os.Stderr, "       %s -s [-v] [-P proc] [-I replacement] command [arguments] < arguments-in-lines\n", os.Args[0])
-	_, _ = fmt.Fprintf(os.Stderr, "       %s --wait\n", os.Args[0])
-	_, _ = fmt.Fprintf(os.Stderr, "       %s --queue-command command [arguments]\n", os.Args[0])
-	_, _ = fmt.Fprintf(os.Stderr, "       %s --queue-command-pid pid command [arguments]\n", os.Args[0])
-	_, _ = fmt.Fprintf(os.Stderr, "       %s --queue-command-ancestor process-name command [arguments]\n\n", os.Args[0])
+	_, _ = fmt.Fprintf(os.Stderr, "       %s -a file [-v] [-P proc] [-I replacement] command [arguments]\n", os.Args[0])
+	for _, name := range subcommandNames {
+		_, _ = fmt.Fprintf(os.Stderr, "       %s %s\n", os.Args[0], subcommands[name].usage)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "\n")
+	usageOfSubcommands()
 	flag.PrintDefaults()
 }
 
@@ -100,14 +207,22 @@ func exitWithUsage(exitCode int) {
 }
 
 func errorWithUsage(format string, args ...any) {
-	_, _ = fmt.Fprintf(os.Stderr, "%s: Argument error: "+format+"\n\n", append([]any{os.Args[0]}, args...))
+	_, _ = fmt.Fprintf(os.Stderr, "%s: Argument error: "+format+"\n\n", append([]any{os.Args[0]}, args...)...)
 	exitWithUsage(1)
 }
 
 func parseArgs() Args {
 	flag.Usage = usage
 	flag.SetInterspersed(false)
+	flag.Lookup("debug").NoOptDefVal = "all"
 	_ = flag.CommandLine.MarkHidden("_execute-and-flush-tty")
+	_ = flag.CommandLine.MarkHidden("_rlimit")
+	_ = flag.CommandLine.MarkHidden("_tmux-job")
+	_ = flag.CommandLine.MarkHidden("_tmux-window")
+	_ = flag.CommandLine.MarkHidden("_unshare-init")
+	applySubcommand()
+	applyGnuCompat()
+	applyXargsCompat()
 	flag.Parse()
 
 	if *flVersion {
@@ -119,7 +234,22 @@ func parseArgs() Args {
 		exitWithUsage(0)
 	}
 
+	color.NoColor = !colorFromFlag()
 	parsedFlMaxMemory = maxMemoryFromFlag()
+	parsedFlSignal = signalFromFlag()
+
+	var err error
+	parsedFlFreeOSMemoryEvery, err = parseSize(*flFreeOSMemoryEvery)
+	if err != nil {
+		errorWithUsage("Invalid value of the --free-os-memory-every flag: %v", err)
+	}
+	parsedFlMaxLineLen, err = parseSize(*flMaxLineLen)
+	if err != nil {
+		errorWithUsage("Invalid value of the --max-line-len flag: %v", err)
+	}
+	if *flMaxRecords < 0 {
+		errorWithUsage("--max-records has to be at least 0, but got %d", *flMaxRecords)
+	}
 	*flMaxProcesses = min(*flMaxProcesses, *flMaxProcessesUpperLimit)
 
 	args := flag.Args()
@@ -133,22 +263,179 @@ func parseArgs() Args {
 
 	exclusiveFlags := flagsPreventingFurtherArguments + countTrue(
 		*flFromStdin,
+		*flArgFile != "",
 		*flExecuteAndFlushTty,
+		*flRlimitExec != "",
+		*flTmuxJob != "",
+		*flTmuxWindow != "",
+		*flUnshareInit != "",
+		*flSubmit != "",
 		queueModeEnabled,
 	)
 
-	if len(args) == 0 && flagsPreventingFurtherArguments == 0 {
+	if len(args) == 0 && flagsPreventingFurtherArguments == 0 && *flListen == "" && *flSubmit == "" {
 		exitWithUsage(1)
 	}
 
+	if *flKillAfter < 0 {
+		errorWithUsage("--kill-after cannot be negative")
+	}
+
+	if _, ok := bufferBackends[*flBufferBackend]; !ok {
+		errorWithUsage("--buffer-backend only accepts 'memory', 'memfd' and 'tempfile', but got '%s'", *flBufferBackend)
+	}
+	if *flBufferBackend == "memfd" {
+		backend, err := newMemfdBackend()
+		if err != nil {
+			errorWithUsage("--buffer-backend memfd is not supported: %v", err)
+		}
+		_ = backend.Close()
+	}
+
+	if *flJson && !*flExplain {
+		errorWithUsage("--json can only be used with --explain")
+	}
+
+	if *flMaxArgs < 0 {
+		errorWithUsage("-n (--max-args) cannot be negative")
+	}
+	templatesFromFlag()
+
+	if *flStallTimeout < 0 {
+		errorWithUsage("--stall-timeout cannot be negative")
+	}
+
+	if *flCsv && (!argumentsFromLines() || *flNull || *flXargsCompat) {
+		errorWithUsage("--csv can only be used with -s (--from-stdin) or --arg-file, and without --null")
+	}
+
+	if len(*flKeepEnv) > 0 && !*flCleanEnv {
+		errorWithUsage("--keep-env can only be used with --clean-env")
+	}
+
+	if *flStdinFile != "" && (*flTee || queueModeEnabled || *flQueueWait) {
+		errorWithUsage("--stdin-file cannot be used with --tee, --wait or the --queue-command flags")
+	}
+
+	if (*flStdout != "" || *flStderr != "") && (*flTmux || *flDiffOutputs || queueModeEnabled || *flQueueWait) {
+		errorWithUsage("--stdout and --stderr cannot be used with --tmux, --diff-outputs, --wait or the --queue-command flags")
+	}
+
+	if *flKeepFailedScratch && !*flScratch {
+		errorWithUsage("--keep-failed-scratch can only be used with --scratch")
+	}
+
+	if *flDiffOutputs && len(*flVariant) < 2 {
+		errorWithUsage("--diff-outputs needs at least two --variant values to compare")
+	}
+	if !*flDiffOutputs && len(*flVariant) > 0 {
+		errorWithUsage("--variant can only be used with --diff-outputs")
+	}
+	if *flDiffOutputs && (*flTee || *flTmux) {
+		errorWithUsage("--diff-outputs cannot be used with --tee or --tmux")
+	}
+
+	if *flSortBySize && *flSortByCost != "" {
+		errorWithUsage("--sort-by-size and --sort-by-cost cannot be used together")
+	}
+	if (*flSortBySize || *flSortByCost != "") && *flListen != "" {
+		errorWithUsage("--sort-by-size and --sort-by-cost cannot be used with --listen, as they need to know every command up front")
+	}
+
+	if *flCanary < 0 {
+		errorWithUsage("--canary has to be at least 0, but got %d", *flCanary)
+	}
+
+	if *flNoRunIfEmpty {
+		*flRunIfEmpty = false
+	}
+	if *flFailIfEmpty && *flRunIfEmpty {
+		errorWithUsage("--fail-if-empty and --run-if-empty cannot be used together")
+	}
+	if *flFailIfEmpty && (*flListen != "" || *flQueueWait) {
+		errorWithUsage("--fail-if-empty can only be used with arguments from \":::\", -s or --arg-file")
+	}
+
+	if *flColumns < 0 || *flColumns > math.MaxUint16 {
+		errorWithUsage("Invalid value of the --columns flag: %d", *flColumns)
+	}
+	if *flRows < 0 || *flRows > math.MaxUint16 {
+		errorWithUsage("Invalid value of the --rows flag: %d", *flRows)
+	}
+	if (*flColumns > 0 || *flRows > 0) && *flTmux {
+		errorWithUsage("--columns and --rows cannot be used with --tmux, as its windows have the size of the terminal attached to them")
+	}
+
+	if *flRawBuffer != "auto" && *flRawBuffer != "always" && *flRawBuffer != "never" {
+		errorWithUsage("--raw-buffer only accepts 'auto', 'always' and 'never', but got '%s'", *flRawBuffer)
+	}
+
+	if *flSpawnAhead < 0 {
+		errorWithUsage("--spawn-ahead has to be at least 0, but got %d", *flSpawnAhead)
+	}
+
+	if *flInputBuffer < 0 {
+		errorWithUsage("--input-buffer has to be at least 0, but got %d", *flInputBuffer)
+	}
+
+	if *flEstimate < 0 {
+		errorWithUsage("--estimate has to be at least 0, but got %d", *flEstimate)
+	}
+	if *flEstimate > 0 && *flListen != "" {
+		errorWithUsage("--estimate cannot be used with --listen, as it needs to know every command up front")
+	}
+
+	if *flBatteryJ < 0 {
+		errorWithUsage("--battery-j has to be at least 0, but got %d", *flBatteryJ)
+	}
+	if *flThrottleTemp > 0 && *flBatteryJ == 0 {
+		errorWithUsage("--throttle-temp needs --battery-j, to know how many commands to run while the CPU is too hot")
+	}
+
+	if *flRlimit != "" {
+		if _, err := parseRlimits(*flRlimit); err != nil {
+			errorWithUsage("Invalid value of the --rlimit flag: %v", err)
+		}
+	}
+
+	controlKeyFromFlag()
+	debugFromFlag()
+	if *flDebugFile != "" && *flDebug == "" {
+		errorWithUsage("--debug-file needs --debug")
+	}
+	credentialsFromFlags()
+	unshareFromFlag()
+	deadlineFromFlag()
+	diskFreeFromFlag()
+	groupByFromFlag()
+	shardFromFlag()
+	if *flShard != "" && *flListen != "" {
+		errorWithUsage("--shard cannot be used with --listen, as submitted commands don't come in a set order")
+	}
+	if *flGroupBy != "" && *flListen != "" {
+		errorWithUsage("--group-by cannot be used with --listen, as it needs to know every command up front")
+	}
+
+	validateNotifyFlag()
+
+	if *flOnHookFailure != "fail" && *flOnHookFailure != "warn" {
+		errorWithUsage("--on-hook-failure only accepts 'fail' and 'warn', but got '%s'", *flOnHookFailure)
+	}
+
+	if *flOnStall != "terminate" && *flOnStall != "warn" {
+		errorWithUsage("--on-stall only accepts 'terminate' and 'warn', but got '%s'", *flOnStall)
+	}
+
 	if *flMaxProcesses < 1 {
 		errorWithUsage("-P (--max-concurrent) cannot be less than 1")
 	}
 
 	if exclusiveFlags > 1 {
-		errorWithUsage("Cannot specify %v, %v, %v, %v, and %v (or %v, or %v) at the same time",
+		errorWithUsage("Cannot specify %v, %v, %v, %v, %v, %v, and %v (or %v, or %v) at the same time",
 			"--from-stdin",
+			"--arg-file",
 			"--_execute-and-flush-tty",
+			"--submit",
 			"--wait",
 			"--show-queue",
 			"--queue-command",
@@ -156,6 +443,56 @@ func parseArgs() Args {
 			"--queue-command-pid")
 	}
 
+	for _, env := range *flEnv {
+		if key, _, found := strings.Cut(env, "="); !found || key == "" {
+			errorWithUsage("Invalid value of the --env flag: '%s' is not in the form of KEY=VALUE", env)
+		}
+	}
+
+	if *flWorkDir != "" && (queueModeEnabled || *flQueueWait) {
+		errorWithUsage("The --wd flag cannot be used with --wait or %s, %s, or %s",
+			"--queue-command",
+			"--queue-command-pid",
+			"--queue-command-ancestor")
+	}
+
+	if *flListen != "" && (queueModeEnabled || *flQueueWait || *flShowQueue || *flSubmit != "") {
+		errorWithUsage("--listen can only be used when running commands")
+	}
+
+	if *flTmux && (queueModeEnabled || *flQueueWait || *flShowQueue || *flSubmit != "") {
+		errorWithUsage("--tmux can only be used when running commands")
+	}
+
+	cacheFromFlag()
+
+	if *flFlockSkip && *flFlock == "" {
+		errorWithUsage("--flock-skip needs --flock")
+	}
+
+	if *flRace && *flHaltOnSuccess {
+		errorWithUsage("--race and --halt-on-success cannot be used together")
+	}
+	if *flRace && *flTmux {
+		errorWithUsage("--race cannot be used with --tmux, as output of commands in tmux windows doesn't go through gparallel")
+	}
+
+	if *flTmux && *flTee {
+		errorWithUsage("--tee cannot be used with --tmux, as commands in tmux windows read from their terminal")
+	}
+
+	if *flWatch < 0 {
+		errorWithUsage("--watch cannot be negative")
+	}
+
+	if *flWatch > 0 && (*flFromStdin || *flTee || *flListen != "" || exclusiveFlags-countTrue(*flArgFile != "") > 0) {
+		errorWithUsage("--watch can only be used when running commands with arguments from \":::\" or --arg-file")
+	}
+
+	if *flPersist != "" && *flListen == "" {
+		errorWithUsage("--persist can only be used with --listen")
+	}
+
 	if *flSlurpStdin && !queueModeEnabled {
 		errorWithUsage("The --slurp-stdin flag can only be specified with %s, %s, or %s",
 			"--queue-command",
@@ -163,14 +500,19 @@ func parseArgs() Args {
 			"--queue-command-ancestor")
 	}
 
-	subcommandSupportsTripleColon := exclusiveFlags < 1
+	subcommandSupportsTripleColon := exclusiveFlags < 1 || (*flCombine != "" && argumentsFromLines() && exclusiveFlags == 1)
+	validateCombineFlag(subcommandSupportsTripleColon && slices.Contains(args, ":::"))
 
 	if subcommandSupportsTripleColon {
 		threeColons := slices.Index(args, ":::")
 		foundTripleColon := threeColons != -1
 
-		if !*flFromStdin && !foundTripleColon {
-			errorWithUsage("don't know where to get arguments from: neither -s (--from-stdin) nor \":::\" specified in the arguments")
+		if !argumentsFromLines() && !foundTripleColon && *flListen == "" {
+			errorWithUsage("don't know where to get arguments from: neither -s (--from-stdin), -a (--arg-file) nor \":::\" specified in the arguments")
+		}
+
+		if *flTee && (argumentsFromLines() || !foundTripleColon) {
+			errorWithUsage("--tee needs arguments given after \":::\", as stdin is passed onto the commands")
 		}
 
 		if foundTripleColon {
@@ -182,12 +524,21 @@ func parseArgs() Args {
 		}
 	}
 
+	if *flTee {
+		errorWithUsage("--tee can only be used when running commands with arguments given after \":::\"")
+	}
+
 	return Args{
 		command: args,
 		data:    []string{},
 	}
 }
 
+// argumentsFromLines tells whether arguments are read line by line, from either stdin or --arg-file
+func argumentsFromLines() bool {
+	return *flFromStdin || *flArgFile != ""
+}
+
 func maxMemoryFromFlag() int64 {
 	totalMemory := memoryStats.TotalMemory()
 
@@ -213,3 +564,44 @@ func maxMemoryFromFlag() int64 {
 
 	return int64(float64(totalMemory) * percentage / 100.0)
 }
+
+// colorFromFlag tells whether to color our own messages, all of which go to stderr
+func colorFromFlag() bool {
+	switch *flColor {
+	case "always":
+		return true
+	case "never":
+		return false
+	case "auto":
+	default:
+		errorWithUsage("--color only accepts 'auto', 'always' and 'never', but got '%s'", *flColor)
+	}
+
+	if force := os.Getenv("CLICOLOR_FORCE"); force != "" && force != "0" {
+		return true
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return term.IsTerminal(syscall.Stderr)
+}
+
+func signalFromFlag() syscall.Signal {
+	if number, err := strconv.Atoi(*flSignal); err == nil {
+		if number <= 0 {
+			errorWithUsage("Invalid value of the --signal flag: %d is not a valid signal number", number)
+		}
+		return syscall.Signal(number)
+	}
+
+	name := strings.ToUpper(*flSignal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		errorWithUsage("Invalid value of the --signal flag: unknown signal '%s'", *flSignal)
+	}
+	return sig
+}
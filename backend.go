@@ -0,0 +1,57 @@
+package main
+
+import "os/exec"
+
+// backend is a way of running the command of a job: what actually gets executed for it, and how its output gets to
+// us. Which one is used is decided once by the flags, in chosenBackend
+type backend interface {
+	// name is how --explain describes the backend
+	name() string
+
+	// wrap returns the command line to execute for the command of a job
+	wrap(command []string) []string
+
+	// start starts the command, giving back where to read its output from
+	start(cmd *exec.Cmd) *Output
+}
+
+var chosenBackend = onceValue(func() backend {
+	switch {
+	case *flTmux:
+		return tmuxBackend{}
+	case usePtys():
+		return ptyBackend{}
+	default:
+		return pipeBackend{}
+	}
+})
+
+// ptyBackend gives every command ptys for its output, so that it behaves like it would in a terminal
+type ptyBackend struct{}
+
+func (ptyBackend) name() string {
+	return "pty"
+}
+
+func (ptyBackend) wrap(command []string) []string {
+	return append([]string{executable(), "--_execute-and-flush-tty"}, command...)
+}
+
+func (ptyBackend) start(cmd *exec.Cmd) *Output {
+	return runInteractive(cmd)
+}
+
+// pipeBackend captures the output of commands through plain pipes
+type pipeBackend struct{}
+
+func (pipeBackend) name() string {
+	return "pipes"
+}
+
+func (pipeBackend) wrap(command []string) []string {
+	return command
+}
+
+func (pipeBackend) start(cmd *exec.Cmd) *Output {
+	return runNonInteractive(cmd)
+}
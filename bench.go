@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// `gparallel bench` replays made-up recordings of what commands print through the same path their output takes -
+// stored in chunks while in the background, then written out with the terminal modes looked at - to see how changes
+// to it affect throughput and allocations. It's not listed among subcommands, as it's only useful for working on
+// gparallel itself
+
+// how much a pty hands out on a single read, roughly
+const benchReadSize = 4096
+
+// benchRecordings make up a recording of at least a given size
+var benchRecordings = []struct {
+	name     string
+	generate func(size int) []byte
+}{
+	{"plain", func(size int) []byte {
+		var recording bytes.Buffer
+		for i := 0; recording.Len() < size; i++ {
+			_, _ = fmt.Fprintf(&recording, "line %d: the quick brown fox jumps over the lazy dog\r\n", i)
+		}
+		return recording.Bytes()
+	}},
+	{"ansi", func(size int) []byte {
+		var recording bytes.Buffer
+		for i := 0; recording.Len() < size; i++ {
+			_, _ = fmt.Fprintf(&recording, "\x1b[1;3%dmerror\x1b[0m: \x1b[4mfile%d.go\x1b[24m:%d: \x1b[33mwarning\x1b[m\r\n", i%8, i, i)
+		}
+		return recording.Bytes()
+	}},
+	{"progress", func(size int) []byte {
+		var recording bytes.Buffer
+		for i := 0; recording.Len() < size; i++ {
+			done := i % 51
+			_, _ = fmt.Fprintf(&recording, "\r\x1b[K[%s%s] %3d%%", strings.Repeat("#", done), strings.Repeat(" ", 50-done), done*2)
+		}
+		return recording.Bytes()
+	}},
+	{"fullscreen", func(size int) []byte {
+		var recording bytes.Buffer
+		recording.WriteString("\x1b[?1049h\x1b[?25l\x1b[?1h\x1b=")
+		for frame := 0; recording.Len() < size; frame++ {
+			recording.WriteString("\x1b[H")
+			for row := 1; row <= 24; row++ {
+				_, _ = fmt.Fprintf(&recording, "\x1b[%d;1H\x1b[7m%5d\x1b[27m \x1b[3%dm%-70d\x1b[39m", row, frame, row%8, frame*row)
+			}
+		}
+		recording.WriteString("\x1b[?1049l\x1b[?25h\x1b[?1l\x1b>")
+		return recording.Bytes()
+	}},
+}
+
+func runBench(args []string) {
+	benchFlags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	size := benchFlags.String("size", "64MiB", "How much output every recording has.")
+	benchFlags.StringVar(flRawBuffer, "raw-buffer", *flRawBuffer, "Like the --raw-buffer of running commands.")
+	if err := benchFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	bytesPerRecording, err := parseSize(*size)
+	if err != nil {
+		log.Fatalf("Invalid value of the --size flag: %v\n", err)
+	}
+
+	defer benchToDevNull()()
+
+	_, _ = fmt.Printf("%-12s %14s %14s %12s %12s\n", "recording", "background", "foreground", "allocs", "allocated")
+	for _, recording := range benchRecordings {
+		data := recording.generate(int(bytesPerRecording))
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		background := benchThroughput(len(data), func() {
+			out := benchStore(data)
+			out.partsMutex.Lock()
+			writeOut(out)
+			out.partsMutex.Unlock()
+		})
+		foreground := benchThroughput(len(data), func() {
+			benchPassThrough(data)
+		})
+
+		runtime.ReadMemStats(&after)
+		_, _ = fmt.Printf("%-12s %9.1f MB/s %9.1f MB/s %12d %12s\n", recording.name, background, foreground,
+			after.Mallocs-before.Mallocs, mebibytes(int64(after.TotalAlloc-before.TotalAlloc)))
+	}
+}
+
+// benchToDevNull makes output written out go to /dev/null, until the returned function is called
+func benchToDevNull() (restore func()) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		log.Fatalf("Could not open %s: %v\n", os.DevNull, err)
+	}
+
+	parsedFlFreeOSMemoryEvery = 64 * 1024 * 1024
+	originalFiles := standardFdToFile
+	standardFdToFile = []*os.File{os.Stdin, devNull, devNull}
+	return func() {
+		standardFdToFile = originalFiles
+		haveToClose(os.DevNull, devNull)
+	}
+}
+
+// benchStore stores a recording like the output of a command in the background, a read at a time
+func benchStore(data []byte) *Output {
+	out := &Output{}
+	for offset := 0; offset < len(data); offset += benchReadSize {
+		out.appendOrWrite(data[offset:min(offset+benchReadSize, len(data))], 1)
+	}
+	return out
+}
+
+// benchPassThrough writes a recording out like the output of a command in the foreground, a read at a time
+func benchPassThrough(data []byte) {
+	out := &Output{shouldPassToParent: true}
+	for offset := 0; offset < len(data); offset += benchReadSize {
+		out.appendOrWrite(data[offset:min(offset+benchReadSize, len(data))], 1)
+	}
+}
+
+// benchThroughput tells how many megabytes of output a second a run goes through
+func benchThroughput(size int, run func()) float64 {
+	startedAt := time.Now()
+	run()
+	return float64(size) / 1e6 / time.Since(startedAt).Seconds()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+// the recordings of `gparallel bench`, made smaller to keep a single iteration short
+const benchTestRecordingSize = 1024 * 1024
+
+func benchmarkRecordings(b *testing.B, run func(b *testing.B, data []byte)) {
+	defer benchToDevNull()()
+
+	for _, recording := range benchRecordings {
+		data := recording.generate(benchTestRecordingSize)
+		b.Run(recording.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			run(b, data)
+		})
+	}
+}
+
+func BenchmarkAppendOrWrite(b *testing.B) {
+	b.Run("background", func(b *testing.B) {
+		benchmarkRecordings(b, func(b *testing.B, data []byte) {
+			for i := 0; i < b.N; i++ {
+				out := benchStore(data)
+				out.allocator.mustFree(out.parts)
+				out.allocator.mustClose()
+			}
+		})
+	})
+	b.Run("foreground", func(b *testing.B) {
+		benchmarkRecordings(b, func(b *testing.B, data []byte) {
+			for i := 0; i < b.N; i++ {
+				benchPassThrough(data)
+			}
+		})
+	})
+}
+
+func BenchmarkWriteOut(b *testing.B) {
+	benchmarkRecordings(b, func(b *testing.B, data []byte) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			out := benchStore(data)
+			b.StartTimer()
+
+			out.partsMutex.Lock()
+			writeOut(out)
+			out.partsMutex.Unlock()
+		}
+	})
+}
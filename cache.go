@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alessio/shellescape"
+)
+
+// With --cache, the output of every command that has succeeded is kept in a file named after a hash of the command,
+// its directory, --env and --stdin-file (and with --cache-files, the contents of arguments that are files). Running
+// the same command again replays that output instead. The file is a transcript of what the command has written:
+// for every read, the fd it's come from, the length as a little-endian uint32, and the data
+
+func cacheFromFlag() {
+	if *flCache == "" {
+		if *flCacheMaxAge != 0 || *flCacheRefresh || *flCacheFiles {
+			errorWithUsage("--cache-max-age, --cache-refresh and --cache-files need --cache")
+		}
+		return
+	}
+
+	if *flCacheMaxAge < 0 {
+		errorWithUsage("--cache-max-age cannot be negative")
+	}
+	if *flTmux || *flStdout != "" || *flStderr != "" {
+		errorWithUsage("--cache cannot be used with --tmux, --stdout or --stderr, as output doesn't go through gparallel then")
+	}
+	if err := os.MkdirAll(*flCache, 0700); err != nil {
+		log.Fatalf("Could not create the --cache directory: %v\n", err)
+	}
+}
+
+// cacheKey hashes everything that decides what a command does, as far as we can tell
+func cacheKey(job *Job) string {
+	hash := sha256.New()
+	write := func(parts ...string) {
+		for _, part := range parts {
+			_, _ = hash.Write([]byte(part))
+			_, _ = hash.Write([]byte{0})
+		}
+	}
+
+	write("command")
+	write(job.command...)
+	write("wd", job.workDir, "stdin", job.stdinFile, "env")
+	write(job.env...)
+
+	if *flCacheFiles {
+		for _, argument := range job.arguments {
+			file, err := os.Open(job.pathInWorkDir(argument))
+			if err != nil {
+				continue
+			}
+			if stat, err := file.Stat(); err == nil && stat.Mode().IsRegular() {
+				write("file", argument)
+				_, _ = io.Copy(hash, file)
+			}
+			_ = file.Close()
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// transcriptChunk is a single read of a command kept in the --cache
+type transcriptChunk struct {
+	fd   int
+	data []byte
+}
+
+// parseTranscript splits a transcript into what's been read from stdout and stderr, refusing one that's been cut
+// short or has been changed into something we could never have written
+func parseTranscript(transcript []byte) (chunks []transcriptChunk, err error) {
+	for len(transcript) > 0 {
+		if len(transcript) < 5 {
+			return nil, fmt.Errorf("it ends in the middle of a header")
+		}
+		fd, length := int(transcript[0]), binary.LittleEndian.Uint32(transcript[1:5])
+		if fd != syscall.Stdout && fd != syscall.Stderr {
+			return nil, fmt.Errorf("it has output from fd %d", fd)
+		}
+		if uint64(length) > uint64(len(transcript)-5) {
+			return nil, fmt.Errorf("it ends %d bytes into output %d bytes long", len(transcript)-5, length)
+		}
+		chunks = append(chunks, transcriptChunk{fd: fd, data: transcript[5 : 5+length]})
+		transcript = transcript[5+length:]
+	}
+	return chunks, nil
+}
+
+// lookupCache returns the transcript of a command kept in the --cache, if there's a valid one that's fresh enough
+func lookupCache(key string) (transcript []transcriptChunk, ok bool) {
+	if *flCacheRefresh {
+		return nil, false
+	}
+
+	path := filepath.Join(*flCache, key)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if *flCacheMaxAge > 0 && time.Since(stat.ModTime()) > *flCacheMaxAge {
+		return nil, false
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Warning: could not read %s from the --cache: %v\n", path, err)
+		return nil, false
+	}
+	transcript, err = parseTranscript(contents)
+	if err != nil {
+		log.Printf("Warning: removing %s from the --cache, as it's broken: %v\n", path, err)
+		_ = os.Remove(path)
+		return nil, false
+	}
+	return transcript, true
+}
+
+// replayFromCache makes a job that has already finished, with the output it's had when it's been cached
+func replayFromCache(job *Job, transcript []transcriptChunk) (result *ProcessResult) {
+	verbosef(2, "Replaying #%d from the --cache: %s", job.seq, abbreviate(shellescape.QuoteCommand(job.command), 200))
+
+	result = &ProcessResult{}
+	result.originalCommand = job.command
+	result.seq = job.seq
+	result.journalId = job.journalId
+	result.groupKey = job.groupKey
+	result.variants = job.variants
+	result.startedAt = time.Now()
+	result.exitCode = make(chan int, 1)
+	result.finished = make(chan struct{})
+
+	job.closeStdin()
+
+	result.output = &Output{}
+	if job.variants != nil {
+		result.output.collectedStdout = &bytes.Buffer{}
+	}
+	for _, chunk := range transcript {
+		waitIfUsingTooMuchMemory(chunkSizeWithHeader(chunk.data), result.output)
+		result.output.appendOrWrite(chunk.data, chunk.fd)
+	}
+
+	result.finishedAt = time.Now()
+	result.finish(0)
+
+	return result
+}
+
+// cacheEntry is the transcript of a running command, which becomes a part of the --cache if it succeeds
+type cacheEntry struct {
+	mutex sync.Mutex
+	key   string
+	file  *os.File
+}
+
+func newCacheEntry(key string) *cacheEntry {
+	file, err := os.CreateTemp(*flCache, ".partial-*")
+	if err != nil {
+		log.Printf("Warning: could not create a file in the --cache: %v\n", err)
+		return nil
+	}
+	return &cacheEntry{key: key, file: file}
+}
+
+func (entry *cacheEntry) record(fd int, data []byte) {
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	if entry.file == nil {
+		return
+	}
+
+	var header [5]byte
+	header[0] = byte(fd)
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(data)))
+	if _, err := entry.file.Write(append(header[:], data...)); err != nil {
+		log.Printf("Warning: could not write to the --cache: %v\n", err)
+		entry.abandon()
+	}
+}
+
+// finish puts the transcript in the --cache if the command has succeeded, and throws it away otherwise
+func (entry *cacheEntry) finish(exitCode int) {
+	if entry == nil {
+		return
+	}
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	if entry.file == nil {
+		return
+	}
+	if exitCode != 0 {
+		entry.abandon()
+		return
+	}
+
+	path := entry.file.Name()
+	if err := entry.file.Close(); err != nil {
+		log.Printf("Warning: could not write to the --cache: %v\n", err)
+		_ = os.Remove(path)
+	} else if err := os.Rename(path, filepath.Join(*flCache, entry.key)); err != nil {
+		log.Printf("Warning: could not add to the --cache: %v\n", err)
+		_ = os.Remove(path)
+	}
+	entry.file = nil
+}
+
+func (entry *cacheEntry) abandon() {
+	_ = entry.file.Close()
+	_ = os.Remove(entry.file.Name())
+	entry.file = nil
+}
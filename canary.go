@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+)
+
+// canaryJobs are the first --canary jobs, which have to succeed before any other one gets started
+type canaryJobs struct {
+	started []*ProcessResult
+}
+
+// add keeps track of a job that has just been spawned, and once all of the canaries are running, waits for them to
+// finish. Returns false if the rest shouldn't be started, because one of them has failed
+func (c *canaryJobs) add(proc *ProcessResult) bool {
+	if proc == nil || len(c.started) >= *flCanary {
+		return true
+	}
+
+	c.started = append(c.started, proc)
+	if len(c.started) < *flCanary {
+		return true
+	}
+
+	failed := 0
+	for _, canary := range c.started {
+		<-canary.finished
+		if canary.finalExitCode != 0 {
+			failed += 1
+		}
+	}
+	if failed == 0 {
+		verbosef(2, "All of the %d --canary commands have succeeded, starting the rest", *flCanary)
+		return true
+	}
+
+	log.Printf("%d of the %d --canary commands have failed, not starting the rest\n", failed, *flCanary)
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"os"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Only with --combine does ":::" separate arguments from the command when they also come from -s or --arg-file -
+// otherwise it's a part of the command, as it has always been. Then 'concat' runs the ":::" ones first and then
+// the lines, 'zip' pairs the n-th argument with the n-th line, and 'product' runs every argument with every line.
+// For the last two the argument and the line both become arguments of one command.
+
+const (
+	combineConcat  = "concat"
+	combineZip     = "zip"
+	combineProduct = "product"
+)
+
+func validateCombineFlag(hasTripleColon bool) {
+	switch *flCombine {
+	case "":
+		return
+	case combineConcat, combineZip, combineProduct:
+	default:
+		errorWithUsage("--combine only accepts 'concat', 'zip' and 'product', but got '%s'", *flCombine)
+	}
+
+	if !hasTripleColon || !argumentsFromLines() {
+		errorWithUsage("--combine needs both arguments after \":::\" and lines of -s or --arg-file")
+	}
+	if *flCombine != combineConcat && flag.CommandLine.Changed("max-args") {
+		errorWithUsage("--combine %s makes up the arguments of every command on its own, so it cannot be used with -n", *flCombine)
+	}
+}
+
+// recordReader reads the items of records of -s or --arg-file input, returning io.EOF once there are no more
+type recordReader func() ([]string, error)
+
+func newRecordReader(input io.Reader) recordReader {
+	if *flCsv {
+		reader := csv.NewReader(input)
+		reader.FieldsPerRecord = -1
+		return reader.Read
+	}
+
+	reader := newArgumentReader(input)
+	atEOF := false
+	return func() ([]string, error) {
+		for !atEOF {
+			items, err := reader.next()
+			atEOF = err == io.EOF
+			if len(items) > 0 {
+				return items, nil
+			}
+		}
+		return nil, io.EOF
+	}
+}
+
+// combinedSource makes jobs out of ":::" arguments together with records of -s or --arg-file, for --combine
+type combinedSource struct {
+	command   []string
+	arguments []string
+	records   recordReader
+
+	record []string
+	// the next argument to use, either with the next record for zip, or with the current one for product
+	position int
+}
+
+func (source *combinedSource) next() (*Job, error) {
+	if *flCombine == combineZip {
+		if source.position == len(source.arguments) {
+			return nil, io.EOF
+		}
+		record, err := source.records()
+		if err == io.EOF {
+			log.Printf("Warning: --combine zip has run out of lines after %d out of %d arguments\n",
+				source.position, len(source.arguments))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		argument := source.arguments[source.position]
+		source.position += 1
+		return newJob(source.command, append([]string{argument}, record...)...), nil
+	}
+
+	if len(source.arguments) == 0 {
+		return nil, io.EOF
+	}
+	if source.record == nil || source.position == len(source.arguments) {
+		record, err := source.records()
+		if err != nil {
+			return nil, err
+		}
+		source.record, source.position = record, 0
+	}
+
+	argument := source.arguments[source.position]
+	source.position += 1
+	return newJob(source.command, append([]string{argument}, source.record...)...), nil
+}
+
+func startProcessesCombined(args Args, result chan<- *ProcessResult) {
+	input := os.Stdin
+	if *flArgFile != "" {
+		file, err := os.Open(*flArgFile)
+		if err != nil {
+			log.Fatalf("Could not open --arg-file: %v\n", err)
+		}
+		defer haveToClose("--arg-file", file)
+		input = file
+	}
+
+	source := &combinedSource{command: args.command, arguments: args.data, records: newRecordReader(input)}
+	startProcessesFrom(withRunIfEmpty(args.command, source), result)
+}
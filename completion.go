@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+	"golang.org/x/exp/slices"
+)
+
+// completionFlag is what a completion script needs to know about one of our flags
+type completionFlag struct {
+	long        string
+	short       string
+	takesValue  bool
+	valueName   string
+	description string
+}
+
+func completionFlags() (flags []completionFlag) {
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if f.Hidden {
+			return
+		}
+		valueName, usage := flag.UnquoteUsage(f)
+		description, _, _ := strings.Cut(usage, "\n")
+		flags = append(flags, completionFlag{
+			long:        f.Name,
+			short:       f.Shorthand,
+			takesValue:  f.NoOptDefVal == "",
+			valueName:   valueName,
+			description: description,
+		})
+	})
+	return flags
+}
+
+// flags whose values are paths to files or directories, everything else isn't worth completing
+var completionFileFlags = []string{"arg-file", "trace"}
+var completionDirectoryFlags = []string{"wd"}
+
+// the flags taking a value, spelled out the way they are given on the command line
+func completionValueFlags() (spellings []string) {
+	for _, f := range completionFlags() {
+		if !f.takesValue {
+			continue
+		}
+		spellings = append(spellings, "--"+f.long)
+		if f.short != "" {
+			spellings = append(spellings, "-"+f.short)
+		}
+	}
+	return spellings
+}
+
+// completionSpellings gives every way of spelling the flags with the long names
+func completionSpellings(longNames []string) (spellings []string) {
+	for _, f := range completionFlags() {
+		if !slices.Contains(longNames, f.long) {
+			continue
+		}
+		spellings = append(spellings, "--"+f.long)
+		if f.short != "" {
+			spellings = append(spellings, "-"+f.short)
+		}
+	}
+	return spellings
+}
+
+func completionAllFlags() (spellings []string) {
+	for _, f := range completionFlags() {
+		spellings = append(spellings, "--"+f.long)
+		if f.short != "" {
+			spellings = append(spellings, "-"+f.short)
+		}
+	}
+	return spellings
+}
+
+// printCompletion implements the completion subcommand
+func printCompletion(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("Usage: %s completion bash|zsh|fish\n", os.Args[0])
+	}
+
+	switch args[0] {
+	case "bash":
+		printBashCompletion()
+	case "zsh":
+		printZshCompletion()
+	case "fish":
+		printFishCompletion()
+	default:
+		log.Fatalf("Cannot generate completion for '%s', only for bash, zsh and fish\n", args[0])
+	}
+}
+
+func printBashCompletion() {
+	_, _ = fmt.Printf(`# bash completion for gparallel, generated by: gparallel completion bash
+_gparallel() {
+    local cur=${COMP_WORDS[COMP_CWORD]}
+    local value_flags=" %s "
+    local expect_value= i word
+
+    # our own options end at the command word - after it, complete whatever that command takes
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        word=${COMP_WORDS[i]}
+        if [[ -n $expect_value ]]; then
+            expect_value=
+        elif [[ $word == -- ]]; then
+            ((i++))
+            break
+        elif [[ $word == -* ]]; then
+            [[ $value_flags == *" $word "* ]] && expect_value=$word
+        elif ((i == 1)) && [[ " %s " == *" $word "* ]]; then
+            continue
+        else
+            break
+        fi
+    done
+
+    if ((i < COMP_CWORD)); then
+        local j
+        for ((j = i + 1; j < COMP_CWORD; j++)); do
+            if [[ ${COMP_WORDS[j]} == ::: ]]; then
+                COMPREPLY=($(compgen -f -- "$cur"))
+                return
+            fi
+        done
+        if declare -F _command_offset >/dev/null; then
+            _command_offset $i
+        else
+            COMPREPLY=($(compgen -f -- "$cur"))
+        fi
+        return
+    fi
+
+    if [[ " %s " == *" $expect_value "* ]]; then
+        COMPREPLY=($(compgen -d -- "$cur"))
+    elif [[ " %s " == *" $expect_value "* ]]; then
+        COMPREPLY=($(compgen -f -- "$cur"))
+    elif [[ -n $expect_value ]]; then
+        COMPREPLY=()
+    elif [[ $cur == -* ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+    elif ((COMP_CWORD == 1)); then
+        COMPREPLY=($(compgen -c -W "%s" -- "$cur"))
+    else
+        COMPREPLY=($(compgen -c -- "$cur"))
+    fi
+}
+complete -o filenames -F _gparallel gparallel
+`,
+		strings.Join(completionValueFlags(), " "),
+		strings.Join(subcommandNames, " "),
+		strings.Join(completionSpellings(completionDirectoryFlags), " "),
+		strings.Join(completionSpellings(completionFileFlags), " "),
+		strings.Join(completionAllFlags(), " "),
+		strings.Join(subcommandNames, " "))
+}
+
+// zshQuote makes a string safe to use in an _arguments spec, inside single quotes
+func zshQuote(s string) string {
+	s = strings.NewReplacer(`[`, `\[`, `]`, `\]`, `:`, `\:`).Replace(s)
+	return strings.ReplaceAll(s, `'`, `'\''`)
+}
+
+func printZshCompletion() {
+	var specs []string
+	for _, f := range completionFlags() {
+		action := ""
+		switch {
+		case slices.Contains(completionFileFlags, f.long):
+			action = ":" + zshQuote(f.valueName) + ":_files"
+		case slices.Contains(completionDirectoryFlags, f.long):
+			action = ":" + zshQuote(f.valueName) + ":_files -/"
+		case f.takesValue:
+			action = ":" + zshQuote(f.valueName) + ": "
+		}
+		specs = append(specs, fmt.Sprintf("'--%s[%s]%s'", f.long, zshQuote(f.description), action))
+		if f.short != "" {
+			specs = append(specs, fmt.Sprintf("'-%s[%s]%s'", f.short, zshQuote(f.description), action))
+		}
+	}
+
+	_, _ = fmt.Printf(`#compdef gparallel
+# zsh completion for gparallel, generated by: gparallel completion zsh
+
+_gparallel_command() {
+    _alternative 'subcommands:subcommand:(%s)' 'commands:command:_command_names -e'
+}
+
+_gparallel_arguments() {
+    # after :::, the arguments are just some words - most probably file names
+    if (( ${words[(I):::]} > 0 && ${words[(I):::]} < CURRENT )); then
+        _files
+    else
+        _normal
+    fi
+}
+
+_gparallel() {
+    _arguments -S \
+        %s \
+        '1: :_gparallel_command' \
+        '*:: :_gparallel_arguments'
+}
+
+if [[ $zsh_eval_context[-1] == loadautofunc ]]; then
+    _gparallel "$@"
+else
+    compdef _gparallel gparallel
+fi
+`,
+		strings.Join(subcommandNames, " "),
+		strings.Join(specs, " \\\n        "))
+}
+
+// fishQuote makes a string safe to use inside single quotes in fish
+func fishQuote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+func printFishCompletion() {
+	_, _ = fmt.Printf(`# fish completion for gparallel, generated by: gparallel completion fish
+
+# prints where the word being completed is: in our own 'options', the 'command', its 'arguments', or 'after-colons'
+function __gparallel_position
+    set -l value_flags %s
+    set -l words (commandline -opc)
+    set -e words[1]
+    set -l expect_value
+    set -l after_dashes
+    set -l index 0
+    for word in $words
+        set index (math $index + 1)
+        if test -n "$expect_value"
+            set expect_value
+        else if test -z "$after_dashes"; and test "$word" = --
+            set after_dashes 1
+        else if test -z "$after_dashes"; and string match -q -- '-*' $word
+            contains -- $word $value_flags; and set expect_value $word
+        else if test $index -eq 1; and contains -- $word %s
+            continue
+        else
+            set -g __gparallel_command_index $index
+            if contains -- ::: $words[$index..-1]
+                echo after-colons
+            else
+                echo arguments
+            end
+            return
+        end
+    end
+    if test -n "$expect_value"
+        echo value
+    else if test -n "$after_dashes"
+        echo command
+    else
+        echo options
+    end
+end
+
+# completes the arguments of the command as if it was run on its own
+function __gparallel_complete_command_arguments
+    set -l words (commandline -opc)
+    set -e words[1]
+    complete -C (string join -- ' ' (string escape -- $words[$__gparallel_command_index..-1]) (commandline -ct))
+end
+
+complete -c gparallel -f
+complete -c gparallel -n 'test (__gparallel_position) = options' -a '(__fish_complete_command)'
+complete -c gparallel -n 'test (__gparallel_position) = options; and test (count (commandline -opc)) -eq 1' -a '%s'
+complete -c gparallel -n 'test (__gparallel_position) = command' -a '(__fish_complete_command)'
+complete -c gparallel -n 'test (__gparallel_position) = after-colons' -F
+complete -c gparallel -n 'test (__gparallel_position) = arguments' -a '(__gparallel_complete_command_arguments)'
+`,
+		strings.Join(completionValueFlags(), " "),
+		strings.Join(subcommandNames, " "),
+		strings.Join(subcommandNames, " "))
+
+	for _, f := range completionFlags() {
+		line := fmt.Sprintf("complete -c gparallel -n 'test (__gparallel_position) = options' -l %s", f.long)
+		if f.short != "" {
+			line += " -s " + f.short
+		}
+		if slices.Contains(completionFileFlags, f.long) || slices.Contains(completionDirectoryFlags, f.long) {
+			line += " -r -F"
+		} else if f.takesValue {
+			line += " -x"
+		}
+		line += fmt.Sprintf(" -d '%s'", fishQuote(f.description))
+		_, _ = fmt.Println(line)
+	}
+}
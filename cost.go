@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/alessio/shellescape"
+)
+
+// jobCost estimates how long a job is going to take, for --sort-by-size and --sort-by-cost: by the total size of the
+// files named in its arguments, or by what the cost command prints
+func jobCost(job *Job, arguments []string) (cost float64) {
+	if *flSortBySize {
+		for _, argument := range arguments {
+			if stat, err := os.Stat(job.pathInWorkDir(argument)); err == nil && stat.Mode().IsRegular() {
+				cost += float64(stat.Size())
+			}
+		}
+		return cost
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", instantiateShellString(*flSortByCost, arguments))
+	cmd.Dir = job.workDir
+	cmd.Env = append(inheritedEnviron(), job.env...)
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	runAsChild(cmd)
+	output, err := cmd.Output()
+	if err == nil {
+		cost, err = strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	}
+	if err != nil {
+		log.Printf("Warning: could not get the cost of %s, running it last: %v\n",
+			abbreviate(shellescape.QuoteCommand(job.command), 200), err)
+		return 0
+	}
+	return cost
+}
+
+// newCostSortedSource reads every job of another source up front, to hand them out starting from the most costly
+// ones - so that a few big jobs don't end up being started last, with everything else already done
+func newCostSortedSource(source inputSource) *jobsSource {
+	jobs := readAllJobs(source)
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].cost > jobs[j].cost
+	})
+	return &jobsSource{jobs: jobs}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// what commands run as with --user and --group, nil to run them as ourselves
+var childCredential *syscall.Credential
+
+// the --user commands run as, to give them its HOME, USER and LOGNAME
+var childUser *user.User
+
+func credentialsFromFlags() {
+	if *flUser == "" && *flGroup == "" {
+		return
+	}
+	if os.Geteuid() != 0 {
+		errorWithUsage("--user and --group can only be used when running as root")
+	}
+	if *flTmux {
+		errorWithUsage("--user and --group cannot be used with --tmux, as commands are started by the tmux server")
+	}
+
+	credential := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid()), Groups: []uint32{}}
+
+	if *flUser != "" {
+		var err error
+		childUser, err = lookupUser(*flUser)
+		if err != nil {
+			errorWithUsage("Invalid value of the --user flag: %v", err)
+		}
+		credential.Uid = parseId(childUser.Uid)
+		credential.Gid = parseId(childUser.Gid)
+
+		// the supplementary groups of the user, rather than the ones we've got as root
+		groupIds, err := childUser.GroupIds()
+		if err != nil {
+			errorWithUsage("Could not get the groups of the user '%s': %v", childUser.Username, err)
+		}
+		for _, groupId := range groupIds {
+			credential.Groups = append(credential.Groups, parseId(groupId))
+		}
+	}
+
+	if *flGroup != "" {
+		group, err := lookupGroup(*flGroup)
+		if err != nil {
+			errorWithUsage("Invalid value of the --group flag: %v", err)
+		}
+		credential.Gid = parseId(group.Gid)
+	}
+
+	childCredential = credential
+}
+
+// lookupUser finds a user by its name, or by its id if it's a number
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupGroupId(name)
+	}
+	return user.LookupGroup(name)
+}
+
+func parseId(id string) uint32 {
+	parsed, _ := strconv.ParseUint(id, 10, 32)
+	return uint32(parsed)
+}
+
+// runAsChild makes a command get started with the --user and --group credentials. Has to be called after setting up
+// the rest of its SysProcAttr
+func runAsChild(cmd *exec.Cmd) {
+	if childCredential == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = childCredential
+}
+
+// childUserEnviron is what commands running as another --user should know about them
+func childUserEnviron() []string {
+	if childUser == nil {
+		return nil
+	}
+	return []string{"HOME=" + childUser.HomeDir, "USER=" + childUser.Username, "LOGNAME=" + childUser.Username}
+}
+
+// giveToChild makes something we've created for a command belong to the --user and --group it runs as
+func giveToChild(path string) error {
+	if childCredential == nil {
+		return nil
+	}
+	return os.Lchown(path, int(childCredential.Uid), int(childCredential.Gid))
+}
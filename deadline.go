@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// what we exit with after the --deadline has passed, the same as timeout(1) does
+const deadlineExitCode = 124
+
+// the layouts of absolute times --deadline accepts, besides durations. Without a date, it's the next such time
+var deadlineLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "15:04:05", "15:04"}
+
+// the time --deadline stands for, zero without it
+var parsedFlDeadline time.Time
+
+// deadlineExceeded is set once the --deadline has passed, with everything still running being terminated
+var deadlineExceeded = atomic.Bool{}
+
+func deadlineFromFlag() {
+	if *flDeadline == "" {
+		return
+	}
+
+	now := time.Now()
+	if duration, err := time.ParseDuration(*flDeadline); err == nil {
+		parsedFlDeadline = now.Add(duration)
+		return
+	}
+
+	for _, layout := range deadlineLayouts {
+		deadline, err := time.ParseInLocation(layout, *flDeadline, time.Local)
+		if err != nil {
+			continue
+		}
+
+		if deadline.Year() == 0 {
+			deadline = time.Date(now.Year(), now.Month(), now.Day(),
+				deadline.Hour(), deadline.Minute(), deadline.Second(), 0, time.Local)
+			if deadline.Before(now) {
+				deadline = deadline.AddDate(0, 0, 1)
+			}
+		}
+		parsedFlDeadline = deadline
+		return
+	}
+
+	errorWithUsage("Invalid value of the --deadline flag: '%s' is neither a duration nor a time like '15:04' or '2006-01-02 15:04'", *flDeadline)
+}
+
+// enforceDeadline stops spawning and terminates every running command once the --deadline passes - their output
+// still gets shown
+func enforceDeadline() {
+	if parsedFlDeadline.IsZero() {
+		return
+	}
+
+	go func() {
+		time.Sleep(time.Until(parsedFlDeadline))
+		deadlineExceeded.Store(true)
+		log.Printf("The --deadline has passed, terminating every running command\n")
+		stopSpawning()
+		terminateAllRunning()
+	}()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// what --debug can log about, as opposed to -v which is meant for users rather than for debugging gparallel itself
+var debugCategories = []string{"memory", "pty", "scheduler", "term"}
+
+// the --debug categories to log, with their messages going to debugLogger
+var parsedFlDebug = map[string]bool{}
+var debugLogger *log.Logger
+
+func debugFromFlag() {
+	if *flDebug == "" {
+		return
+	}
+
+	for _, category := range strings.Split(*flDebug, ",") {
+		category = strings.TrimSpace(category)
+		switch {
+		case category == "all":
+			for _, category := range debugCategories {
+				parsedFlDebug[category] = true
+			}
+		case slices.Contains(debugCategories, category):
+			parsedFlDebug[category] = true
+		default:
+			errorWithUsage("Invalid value of the --debug flag: unknown category '%s', expected all or %s",
+				category, strings.Join(debugCategories, ", "))
+		}
+	}
+
+	output := os.Stderr
+	if *flDebugFile != "" {
+		var err error
+		output, err = os.OpenFile(*flDebugFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			errorWithUsage("Could not open --debug-file: %v", err)
+		}
+	}
+	debugLogger = log.New(output, fmt.Sprintf("gparallel[%d] ", os.Getpid()), log.Ltime|log.Lmicroseconds)
+}
+
+// debugf logs a message if --debug has been asked to log its category
+func debugf(category string, format string, args ...any) {
+	if parsedFlDebug[category] {
+		debugLogger.Printf("%s: "+format+"\n", append([]any{category}, args...)...)
+	}
+}
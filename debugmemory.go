@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alessio/shellescape"
+)
+
+// every job that has been spawned but not yet shown in the foreground - for --debug-memory
+var buffering = struct {
+	mutex     sync.Mutex
+	processes map[*ProcessResult]struct{}
+}{
+	processes: map[*ProcessResult]struct{}{},
+}
+
+func addBuffering(proc *ProcessResult) {
+	buffering.mutex.Lock()
+	defer buffering.mutex.Unlock()
+
+	buffering.processes[proc] = struct{}{}
+}
+
+func removeBuffering(proc *ProcessResult) {
+	buffering.mutex.Lock()
+	defer buffering.mutex.Unlock()
+
+	delete(buffering.processes, proc)
+}
+
+func mebibytes(bytes int64) string {
+	return fmt.Sprintf("%.1f MiB", float64(bytes)/1024/1024)
+}
+
+// startDebuggingMemory logs how output buffering is doing every --debug-memory, to help with tuning --max-mem and -P
+func startDebuggingMemory() {
+	if *flDebugMemory <= 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(*flDebugMemory) {
+			logMemoryUsage()
+		}
+	}()
+}
+
+func logMemoryUsage() {
+	type jobBuffer struct {
+		command  []string
+		used     int64
+		reserved int64
+	}
+
+	var jobs []jobBuffer
+	var used, reserved int64
+	var blocked int
+
+	buffering.mutex.Lock()
+	for proc := range buffering.processes {
+		proc.output.partsMutex.Lock()
+		job := jobBuffer{proc.originalCommand, int64(len(proc.output.parts)), int64(cap(proc.output.parts))}
+		proc.output.partsMutex.Unlock()
+
+		if proc.output.waitingForMemory.Load() {
+			blocked += 1
+		}
+		used += job.used
+		reserved += job.reserved
+		jobs = append(jobs, job)
+	}
+	buffering.mutex.Unlock()
+
+	running.mutex.Lock()
+	ptys := 0
+	if usePtys() {
+		ptysPerJob := 2
+		if stdoutAndStderrAreTheSame() {
+			ptysPerJob = 1
+		}
+		ptys = len(running.processes) * ptysPerJob
+	}
+	running.mutex.Unlock()
+
+	var goMemory runtime.MemStats
+	runtime.ReadMemStats(&goMemory)
+
+	report := &strings.Builder{}
+	_, _ = fmt.Fprintf(report, "Memory: %s stored of %s allowed (high-water mark %s), %s reserved by the %s backend, %d ptys open\n",
+		mebibytes(mem.currentlyStored.Load()),
+		mebibytes(parsedFlMaxMemory),
+		mebibytes(mem.highWaterMark.Load()),
+		mebibytes(reserved),
+		*flBufferBackend,
+		ptys)
+	_, _ = fmt.Fprintf(report, "  %d jobs buffering, %d of them blocked on memory right now, %d times blocked so far\n",
+		len(jobs),
+		blocked,
+		mem.timesBlocked.Load())
+	if reserved > 0 {
+		_, _ = fmt.Fprintf(report, "  %s (%.0f%%) of reserved buffers unused\n",
+			mebibytes(reserved-used),
+			float64(reserved-used)/float64(reserved)*100)
+	}
+	_, _ = fmt.Fprintf(report, "  Go heap: %s in use, %s idle, %s returned to the OS\n",
+		mebibytes(int64(goMemory.HeapInuse)),
+		mebibytes(int64(goMemory.HeapIdle)),
+		mebibytes(int64(goMemory.HeapReleased)))
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].used > jobs[j].used })
+	for _, job := range jobs {
+		if job.reserved == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(report, "  %10s of %10s  %s\n",
+			mebibytes(job.used),
+			mebibytes(job.reserved),
+			abbreviate(shellescape.QuoteCommand(job.command), 200))
+	}
+
+	log.Print(report.String())
+}
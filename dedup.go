@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"log"
+	"strings"
+)
+
+// dedupSource skips the jobs of another source that would run exactly the same command as one before them, for --dedup
+type dedupSource struct {
+	inputSource
+	seen    map[string]bool
+	skipped int
+}
+
+func newDedupSource(source inputSource) *dedupSource {
+	return &dedupSource{inputSource: source, seen: map[string]bool{}}
+}
+
+func (source *dedupSource) next() (*Job, error) {
+	for {
+		job, err := source.inputSource.next()
+		if err == io.EOF && source.skipped > 0 {
+			log.Printf("Skipped %d duplicate commands\n", source.skipped)
+			source.skipped = 0
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		key := strings.Join(job.command, "\x00") + "\x00" + job.workDir
+		if source.seen[key] {
+			source.skipped += 1
+			job.abandon()
+			continue
+		}
+		source.seen[key] = true
+
+		// the source has counted the duplicates as well
+		job.total = 0
+		return job, nil
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/alessio/shellescape"
+)
+
+// variantPlaceholder gets replaced with every --variant in turn, with --diff-outputs. The variants are a flag of their
+// own rather than another group of ":::" arguments, as several of those already get combined into the arguments of
+// one command by --combine - there'd be no telling which group is the one to compare
+const variantPlaceholder = "{variant}"
+
+// variantGroup is every run of one command, differing only in the --variant
+type variantGroup struct {
+	label     string
+	stdouts   []*bytes.Buffer
+	exitCodes []int
+}
+
+// variantsSource runs every job of another source once for every --variant
+type variantsSource struct {
+	inputSource
+	pending []*Job
+}
+
+func (source *variantsSource) next() (*Job, error) {
+	if len(source.pending) == 0 {
+		job, err := source.inputSource.next()
+		if err != nil {
+			return nil, err
+		}
+		source.pending = variantsOf(job)
+	}
+
+	job := source.pending[0]
+	source.pending = source.pending[1:]
+	return job, nil
+}
+
+func variantsOf(job *Job) (variants []*Job) {
+	group := &variantGroup{label: shellescape.QuoteCommand(job.command)}
+
+	for _, variant := range *flVariant {
+		replace := func(s string) string {
+			return strings.ReplaceAll(s, variantPlaceholder, variant)
+		}
+
+		copied := *job
+		copied.command = nil
+		for _, word := range job.command {
+			copied.command = append(copied.command, replace(word))
+		}
+		copied.env = nil
+		for _, env := range job.env {
+			copied.env = append(copied.env, replace(env))
+		}
+		copied.workDir = replace(job.workDir)
+		copied.stdinFile = replace(job.stdinFile)
+		copied.stdoutFile = replace(job.stdoutFile)
+		copied.stderrFile = replace(job.stderrFile)
+		copied.before = replace(job.before)
+		copied.after = replace(job.after)
+		copied.total = job.total * len(*flVariant)
+		copied.variants = group
+
+		variants = append(variants, &copied)
+	}
+	return variants
+}
+
+// recordVariant keeps the output of a command that has just been shown, and once every variant of it has finished
+// compares them, telling whether they've differed
+func recordVariant(proc *ProcessResult, exitCode int) (differed bool) {
+	group := proc.variants
+	group.stdouts = append(group.stdouts, proc.output.collectedStdout)
+	group.exitCodes = append(group.exitCodes, exitCode)
+	if len(group.stdouts) < len(*flVariant) {
+		return false
+	}
+
+	defer group.release()
+
+	for i := 1; i < len(group.stdouts); i++ {
+		if group.exitCodes[i] != group.exitCodes[0] || !bytes.Equal(group.stdouts[i].Bytes(), group.stdouts[0].Bytes()) {
+			differed = true
+		}
+	}
+
+	if !differed {
+		_, _ = fmt.Printf("%s %s\n", bold("Same:"), group.label)
+		return false
+	}
+
+	_, _ = fmt.Printf("%s %s\n", bold("Differs:"), group.label)
+	for i := 1; i < len(group.stdouts); i++ {
+		if group.exitCodes[i] != group.exitCodes[0] {
+			_, _ = fmt.Printf("exit code %d with %s, %d with %s\n",
+				group.exitCodes[0], (*flVariant)[0], group.exitCodes[i], (*flVariant)[i])
+		}
+		if err := printDiff((*flVariant)[0], group.stdouts[0], (*flVariant)[i], group.stdouts[i]); err != nil {
+			log.Printf("Warning: could not show how the output with %s differs: %v\n", (*flVariant)[i], err)
+		}
+	}
+	return true
+}
+
+// printDiff shows a unified diff of two outputs, made by diff(1)
+func printDiff(nameA string, a *bytes.Buffer, nameB string, b *bytes.Buffer) error {
+	if bytes.Equal(a.Bytes(), b.Bytes()) {
+		return nil
+	}
+
+	dir, err := os.MkdirTemp("", "gparallel-diff-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	pathA, pathB := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.WriteFile(pathA, a.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(pathB, b.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("diff", "-u", "--label", nameA, "--label", nameB, pathA, pathB)
+	cmd.Stdout = os.Stdout
+	err = cmd.Run()
+
+	// diff exits with 1 when the files differ, which is what we already know
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return nil
+	}
+	return err
+}
+
+// collectsStdout tells whether an output gets kept for --diff-outputs instead of being written out
+func (out *Output) collectsStdout(fd int) bool {
+	return out.collectedStdout != nil && fd == 1
+}
+
+// collect keeps stdout for --diff-outputs. It's counted as stored output until every variant has been compared, so
+// that commands in the background get paused by --max-mem instead of us growing past it
+func (out *Output) collect(data []byte) {
+	out.collectedStdout.Write(data)
+
+	mem.childDiedFreeingMemory.L.Lock()
+	defer mem.childDiedFreeingMemory.L.Unlock()
+	countStored(int64(len(data)))
+}
+
+// release lets go of the outputs of every variant once they've been compared
+func (group *variantGroup) release() {
+	var collected int64
+	for _, stdout := range group.stdouts {
+		collected += int64(stdout.Len())
+	}
+	group.stdouts = nil
+
+	mem.childDiedFreeingMemory.L.Lock()
+	defer mem.childDiedFreeingMemory.L.Unlock()
+	mem.currentlyStored.Add(-collected)
+	mem.childDiedFreeingMemory.Broadcast()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// how often to check free space again while waiting for it, with --diskfree
+const diskFreePollInterval = time.Second
+
+// diskFreeThreshold is a filesystem that has to have some space free before another command gets started
+type diskFreeThreshold struct {
+	path string
+	free int64
+}
+
+var parsedFlDiskFree []diskFreeThreshold
+
+func diskFreeFromFlag() {
+	for _, value := range *flDiskFree {
+		// the size goes after the last colon, as paths can contain them as well
+		separator := strings.LastIndex(value, ":")
+		if separator <= 0 {
+			errorWithUsage("Invalid value of the --diskfree flag: expected PATH:SIZE, like /tmp:20GiB, but got '%s'", value)
+		}
+
+		free, err := parseSize(value[separator+1:])
+		if err != nil {
+			errorWithUsage("Invalid value of the --diskfree flag: %v", err)
+		}
+		path := value[:separator]
+		if _, err := availableSpace(path); err != nil {
+			errorWithUsage("Invalid value of the --diskfree flag: cannot check free space on %s: %v", path, err)
+		}
+		parsedFlDiskFree = append(parsedFlDiskFree, diskFreeThreshold{path: path, free: free})
+	}
+}
+
+func gibibytes(bytes int64) string {
+	return fmt.Sprintf("%.1f GiB", float64(bytes)/1024/1024/1024)
+}
+
+// waitForDiskSpace holds off starting another command while any --diskfree filesystem has less space free than it
+// should. Returns false if we've stopped spawning in the meantime
+func waitForDiskSpace() bool {
+	for _, threshold := range parsedFlDiskFree {
+		waitingSince := time.Time{}
+		for {
+			available, err := availableSpace(threshold.path)
+			if err != nil {
+				log.Printf("Warning: could not check free space on %s for --diskfree: %v\n", threshold.path, err)
+				break
+			}
+			if available >= threshold.free {
+				if !waitingSince.IsZero() {
+					verbosef(2, "%s has %s free again, after waiting %v", threshold.path, gibibytes(available),
+						time.Since(waitingSince).Round(time.Second))
+				}
+				break
+			}
+
+			if waitingSince.IsZero() {
+				waitingSince = time.Now()
+				log.Printf("Waiting for %s to have %s free (it has %s) before starting more commands\n",
+					threshold.path, gibibytes(threshold.free), gibibytes(available))
+			}
+			select {
+			case <-time.After(diskFreePollInterval):
+			case <-spawning.Done():
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,12 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// availableSpace uses statvfs, as NetBSD hasn't got statfs anymore
+func availableSpace(path string) (int64, error) {
+	var stat unix.Statvfs_t
+	if err := unix.Statvfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail * stat.Frsize), nil
+}
@@ -0,0 +1,11 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+func availableSpace(path string) (int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(uint64(stat.F_bavail) * uint64(stat.F_bsize)), nil
+}
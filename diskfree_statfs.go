@@ -0,0 +1,13 @@
+//go:build !netbsd && !openbsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+func availableSpace(path string) (int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// runEstimate extrapolates how long the whole run is going to take from how long the first --estimate jobs took
+type runEstimate struct {
+	total   int
+	sampled []*ProcessResult
+}
+
+// add keeps track of a job that has just been spawned, and once all the sampled ones are running, waits for them to
+// finish and prints the estimate - before any other job gets started
+func (estimate *runEstimate) add(proc *ProcessResult) {
+	if proc == nil || len(estimate.sampled) >= *flEstimate || estimate.total <= *flEstimate {
+		return
+	}
+
+	estimate.sampled = append(estimate.sampled, proc)
+	if len(estimate.sampled) < *flEstimate {
+		return
+	}
+
+	var took time.Duration
+	for _, sampled := range estimate.sampled {
+		<-sampled.finished
+		took += sampled.finishedAt.Sub(sampled.startedAt)
+	}
+	average := took / time.Duration(len(estimate.sampled))
+
+	remaining := estimate.total - len(estimate.sampled)
+	concurrent := min(*flMaxProcesses, remaining)
+	rounds := (remaining + concurrent - 1) / concurrent
+	left := average * time.Duration(rounds)
+
+	_, _ = fmt.Fprintf(os.Stderr, "%s the first %d commands took %v on average - the other %d should take about %v more, "+
+		"finishing around %s (^C stops starting them).\n",
+		bold("Estimate:"), len(estimate.sampled), average.Round(time.Millisecond), remaining, left.Round(time.Second),
+		time.Now().Add(left).Format("15:04:05"))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"golang.org/x/exp/slices"
+)
+
+// explainedJob is how a job gets described by --explain --json
+type explainedJob struct {
+	Seq     int      `json:"seq"`
+	Argv    []string `json:"argv"`
+	Env     []string `json:"env"`
+	WorkDir string   `json:"workDir,omitempty"`
+	Stdin   string   `json:"stdin"`
+	Capture string   `json:"capture"`
+	Buffer  string   `json:"buffer"`
+}
+
+var explainedJobs int
+
+func describeJob(job *Job) explainedJob {
+	job.seq = int(startedJobs.Add(1))
+
+	// GPARALLEL_SLOT is left out, as it depends on which jobs happen to be running at the time
+	var env []string
+	if *flCleanEnv {
+		// it's all there is, so show all of it - just like the command is going to get it
+		env = slices.DeleteFunc(effectiveEnviron(job.environ(0)), func(variable string) bool {
+			return strings.HasPrefix(variable, "GPARALLEL_SLOT=")
+		})
+	} else {
+		env = append(env, job.env...)
+		env = append(env, fmt.Sprintf("GPARALLEL_SEQ=%d", job.seq))
+		if job.total > 0 {
+			env = append(env, fmt.Sprintf("GPARALLEL_TOTAL=%d", job.total))
+		}
+	}
+
+	stdin := "none"
+	if *flTee {
+		stdin = "tee"
+	} else if job.stdin != nil {
+		stdin = "queued"
+	}
+
+	return explainedJob{
+		Seq:     job.seq,
+		Argv:    job.command,
+		Env:     env,
+		WorkDir: job.workDir,
+		Stdin:   stdin,
+		Capture: chosenBackend().name(),
+		Buffer:  *flBufferBackend,
+	}
+}
+
+// effectiveEnviron leaves only the last value of every variable, which is the one a command gets
+func effectiveEnviron(env []string) []string {
+	seen := make(map[string]bool, len(env))
+	effective := make([]string, 0, len(env))
+	for i := len(env) - 1; i >= 0; i-- {
+		name, _, _ := strings.Cut(env[i], "=")
+		if !seen[name] {
+			seen[name] = true
+			effective = append(effective, env[i])
+		}
+	}
+	slices.Reverse(effective)
+	return effective
+}
+
+// explainJob prints what would be done to run a job, for --explain
+func explainJob(job *Job) {
+	description := describeJob(job)
+	job.closeStdin()
+
+	if !*flJson {
+		_, _ = fmt.Printf("#%d: %s\n", description.Seq, shellescape.QuoteCommand(description.Argv))
+		_, _ = fmt.Printf("    env: %s\n", strings.Join(description.Env, " "))
+		if description.WorkDir != "" {
+			_, _ = fmt.Printf("    wd: %s\n", description.WorkDir)
+		}
+		_, _ = fmt.Printf("    stdin: %s, output captured with %s and buffered in %s\n",
+			description.Stdin, description.Capture, description.Buffer)
+		return
+	}
+
+	encoded, err := json.MarshalIndent(description, "  ", "  ")
+	if err != nil {
+		log.Fatalf("Could not encode the job as JSON: %v\n", err)
+	}
+
+	// the list gets written out a job at a time, so that it can be read while arguments are still coming in
+	separator := ",\n  "
+	if explainedJobs == 0 {
+		separator = "[\n  "
+	}
+	explainedJobs += 1
+	_, _ = os.Stdout.WriteString(separator + string(encoded))
+}
+
+// finishExplaining ends the list of jobs printed by --explain --json
+func finishExplaining() {
+	if !*flExplain || !*flJson {
+		return
+	}
+	if explainedJobs == 0 {
+		_, _ = os.Stdout.WriteString("[]\n")
+	} else {
+		_, _ = os.Stdout.WriteString("\n]\n")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+// finishListeners get told about every job as soon as it has finished - which can be long before its output gets
+// shown, as that only happens in order
+var finishListeners []func(proc *ProcessResult)
+
+// onFinish adds a listener for jobs finishing. Listeners have to be added before any job starts, and get called from
+// whichever goroutine has seen a job finish, so they must not block
+func onFinish(listener func(proc *ProcessResult)) {
+	finishListeners = append(finishListeners, listener)
+}
+
+// finish records how a job has ended, tells every listener about it, and wakes up everyone waiting for it
+func (proc *ProcessResult) finish(exitCode int) {
+	proc.finalExitCode = exitCode
+	for _, listener := range finishListeners {
+		listener(proc)
+	}
+	close(proc.finished)
+	proc.exitCode <- exitCode
+}
+
+func addFinishListeners() {
+	if !*flKeepGoingOnError && !*flHaltOnSuccess {
+		onFinish(stopSpawningOnFailure)
+	}
+	if *flHaltOnSuccess {
+		onFinish(haltOnSuccess)
+	}
+	if *flRace {
+		onFinish(func(proc *ProcessResult) { enterRace(proc.output) })
+	}
+}
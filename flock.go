@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/alessio/shellescape"
+)
+
+// how often to try taking a --flock again while someone else holds it
+const flockRetryInterval = 100 * time.Millisecond
+
+// takeLock takes the --flock of a job, waiting for whoever holds it - unless --flock-skip says to skip the job
+// instead. Returns false if the job shouldn't run
+func (job *Job) takeLock() (ok bool, err error) {
+	if job.lockPath == "" {
+		return true, nil
+	}
+
+	job.lock, err = os.OpenFile(job.pathInWorkDir(job.lockPath), os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return false, err
+	}
+
+	waitedSince := time.Now()
+	for {
+		err := syscall.Flock(int(job.lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			if waited := time.Since(waitedSince); waited >= flockRetryInterval {
+				verbosef(3, "Waited %v for the --flock %s", waited.Round(time.Millisecond), job.lockPath)
+			}
+			return true, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			job.releaseLock()
+			return false, err
+		}
+
+		if *flFlockSkip {
+			verbosef(2, "Skipping %s, as %s is locked", abbreviate(shellescape.QuoteCommand(job.command), 200), job.lockPath)
+			job.releaseLock()
+			return false, nil
+		}
+
+		select {
+		case <-time.After(flockRetryInterval):
+		case <-spawning.Done():
+			job.releaseLock()
+			return false, nil
+		}
+	}
+}
+
+// releaseLock lets go of the --flock of a job, if it has one
+func (job *Job) releaseLock() {
+	if job.lock != nil {
+		// closing the file releases the lock
+		_ = job.lock.Close()
+		job.lock = nil
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+	"golang.org/x/exp/slices"
+)
+
+// GNU parallel options describing what we always do anyway
+var ignoredGnuParallelOptions = []string{"k", "keep-order", "group", "lb", "line-buffer", "q", "quote"}
+
+// GNU parallel options that would silently change what a script means if we ignored them, along with why
+var unsupportedGnuParallelOptions = map[string]string{
+	"u":         "output is always grouped by command",
+	"ungroup":   "output is always grouped by command",
+	"tag":       "output lines can't be prefixed",
+	"tagstring": "output lines can't be prefixed",
+	"X":         "the context around the replacement string can't be repeated for every argument, -m can be used instead",
+	"pipe":      "stdin can't be split between commands",
+	"S":         "commands can only be run locally",
+	"sshlogin":  "commands can only be run locally",
+	"shuf":      "commands are always run in order",
+}
+
+// usesGnuCompat tells whether --gnu-compat is one of the options before the command
+func usesGnuCompat(args []string) bool {
+	for _, arg := range args {
+		if arg == "--gnu-compat" {
+			return true
+		}
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			return false
+		}
+	}
+	return false
+}
+
+// translateGnuParallelArgs rewrites the options of GNU parallel preceding the command into ours. Options that
+// describe what gparallel always does anyway are dropped, and ones that can't be supported are rejected instead of
+// ending up as words of the command
+func translateGnuParallelArgs(args []string) (translated []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || arg == "-" || !strings.HasPrefix(arg, "-") {
+			return append(translated, args[i:]...)
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		isLong := strings.HasPrefix(arg, "--")
+
+		// short options can have their value glued to them, like -j4
+		if !isLong && len(name) > 1 && !hasValue {
+			name, value, hasValue = name[:1], name[1:], true
+		}
+
+		takeValue := func() string {
+			if hasValue {
+				return value
+			}
+			if i+1 >= len(args) {
+				errorWithUsage("GNU parallel's %s needs a value", arg)
+			}
+			i += 1
+			return args[i]
+		}
+
+		switch name {
+		case "gnu-compat":
+			continue
+		case "j", "jobs":
+			jobs := gnuParallelJobs(takeValue())
+			translated = append(translated, "--max-concurrent", jobs, "--max-concurrent-upper-limit", jobs)
+			continue
+		case "m", "xargs":
+			translated = append(translated, "--max-args", "0")
+			continue
+		case "halt", "halt-on-error":
+			translated = append(translated, gnuParallelHalt(takeValue())...)
+			continue
+		}
+
+		if slices.Contains(ignoredGnuParallelOptions, name) {
+			continue
+		}
+		if reason, unsupported := unsupportedGnuParallelOptions[name]; unsupported {
+			errorWithUsage("GNU parallel's %s is not supported: %s", arg, reason)
+		}
+
+		var ours *flag.Flag
+		if isLong {
+			ours = flag.CommandLine.Lookup(name)
+		} else {
+			ours = flag.CommandLine.ShorthandLookup(name)
+		}
+		if ours == nil || ours.Hidden {
+			errorWithUsage("GNU parallel's %s is not supported by gparallel", arg)
+		}
+
+		// one of our own options - pass it on as it is, along with its value
+		translated = append(translated, arg)
+		if ours.NoOptDefVal == "" && !strings.Contains(arg, "=") && (isLong || len(arg) == 2) && i+1 < len(args) {
+			i += 1
+			translated = append(translated, args[i])
+		}
+	}
+
+	return translated
+}
+
+// gnuParallelJobs turns GNU parallel's -j value, which can be relative to the number of cores, into a number of jobs
+func gnuParallelJobs(value string) string {
+	cores := max(runtime.NumCPU(), 1)
+
+	var jobs int
+	var err error
+	switch {
+	case strings.HasSuffix(value, "%"):
+		jobs, err = strconv.Atoi(strings.TrimSuffix(value, "%"))
+		jobs = cores * jobs / 100
+	case strings.HasPrefix(value, "+"):
+		jobs, err = strconv.Atoi(strings.TrimPrefix(value, "+"))
+		jobs = cores + jobs
+	case strings.HasPrefix(value, "-"):
+		jobs, err = strconv.Atoi(strings.TrimPrefix(value, "-"))
+		jobs = cores - jobs
+	default:
+		jobs, err = strconv.Atoi(value)
+		if err == nil && jobs == 0 {
+			errorWithUsage("GNU parallel's -j 0 (as many jobs as possible) is not supported")
+		}
+	}
+	if err != nil {
+		errorWithUsage("Invalid value of GNU parallel's -j: %s", value)
+	}
+
+	return strconv.Itoa(max(jobs, 1))
+}
+
+// gnuParallelHalt maps the --halt settings that match what we can do: stop at the first failure (which we do by
+// default), at the first success, or never stop
+func gnuParallelHalt(value string) []string {
+	switch value {
+	case "never", "0":
+		return []string{"--keep-going-on-error"}
+	case "soon,fail=1", "now,fail=1", "1", "2":
+		return nil
+	case "now,success=1", "soon,success=1":
+		return []string{"--halt-on-success"}
+	}
+
+	supported := []string{"never", "soon,fail=1", "now,fail=1", "now,success=1", "soon,success=1"}
+	errorWithUsage("GNU parallel's --halt %s is not supported, only %s are", value, strings.Join(supported, ", "))
+	return nil
+}
+
+func applyGnuCompat() {
+	if !usesGnuCompat(os.Args[1:]) {
+		return
+	}
+
+	os.Args = append(os.Args[:1], translateGnuParallelArgs(os.Args[1:])...)
+}
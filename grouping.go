@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --group-by takes either the number of an argument, or a regular expression matched against all of them
+var parsedFlGroupBy struct {
+	argument int
+	regexp   *regexp.Regexp
+}
+
+func groupByFromFlag() {
+	if *flGroupBy == "" {
+		return
+	}
+
+	if number, err := strconv.Atoi(*flGroupBy); err == nil {
+		if number < 1 {
+			errorWithUsage("Invalid value of the --group-by flag: arguments are numbered from 1, but got %d", number)
+		}
+		parsedFlGroupBy.argument = number
+		return
+	}
+
+	var err error
+	parsedFlGroupBy.regexp, err = regexp.Compile(*flGroupBy)
+	if err != nil {
+		errorWithUsage("Invalid value of the --group-by flag: %v", err)
+	}
+}
+
+// groupKey tells which group a job with the arguments belongs to: the --group-by argument, or the first capture group
+// of the --group-by regexp (the whole match if it hasn't got any)
+func groupKey(arguments []string) string {
+	if parsedFlGroupBy.argument > 0 {
+		if parsedFlGroupBy.argument > len(arguments) {
+			return ""
+		}
+		return arguments[parsedFlGroupBy.argument-1]
+	}
+
+	match := parsedFlGroupBy.regexp.FindStringSubmatch(strings.Join(arguments, " "))
+	switch len(match) {
+	case 0:
+		return ""
+	case 1:
+		return match[0]
+	default:
+		return match[1]
+	}
+}
+
+// newGroupedSource reads every job of another source up front, to hand them out sorted by their --group-by key - so
+// that jobs of one group run and get shown one after another
+func newGroupedSource(source inputSource) *jobsSource {
+	jobs := readAllJobs(source)
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].groupKey < jobs[j].groupKey
+	})
+	return &jobsSource{jobs: jobs}
+}
+
+// lastShownGroup is the --group-by key of the job whose output has been shown last
+var lastShownGroup *string
+
+// showGroupHeader starts the section of a new group before showing the output of its first job
+func showGroupHeader(proc *ProcessResult) {
+	if lastShownGroup != nil && *lastShownGroup == proc.groupKey {
+		return
+	}
+
+	if lastShownGroup != nil {
+		_, _ = fmt.Println()
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "==> %s <==\n", proc.groupKey)
+	lastShownGroup = &proc.groupKey
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+)
+
+// firstSuccess is the command that has succeeded first with --halt-on-success, nil until one has
+var firstSuccess = struct {
+	mutex sync.Mutex
+	proc  *ProcessResult
+}{}
+
+// haltOnSuccess stops starting commands and terminates the running ones once one of them has succeeded, with
+// --halt-on-success
+func haltOnSuccess(proc *ProcessResult) {
+	if proc.finalExitCode != 0 {
+		return
+	}
+
+	firstSuccess.mutex.Lock()
+	defer firstSuccess.mutex.Unlock()
+	if firstSuccess.proc != nil {
+		return
+	}
+	firstSuccess.proc = proc
+
+	verbosef(1, "#%d has succeeded, terminating the rest", proc.seq)
+	stopSpawning()
+
+	running.mutex.Lock()
+	defer running.mutex.Unlock()
+	for other := range running.processes {
+		if other != proc {
+			terminate(other)
+		}
+	}
+}
+
+func succeededFirst() *ProcessResult {
+	firstSuccess.mutex.Lock()
+	defer firstSuccess.mutex.Unlock()
+
+	return firstSuccess.proc
+}
+
+// discardOutput waits for a command that's been terminated because another one has succeeded (or won the --race),
+// and frees what it has stored without showing it
+func discardOutput(proc *ProcessResult) {
+	terminate(proc)
+	<-proc.finished
+	removeBuffering(proc)
+
+	proc.output.partsMutex.Lock()
+	defer proc.output.partsMutex.Unlock()
+
+	var clearedOutBytes int64
+	offset := 0
+	for {
+		_, content, ok := proc.output.getNextChunk(&offset)
+		if !ok {
+			break
+		}
+		clearedOutBytes += chunkSizeWithHeader(content)
+	}
+	releaseOutput(proc.output, clearedOutBytes)
+}
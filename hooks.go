@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/alessio/shellescape"
+)
+
+// capturedOutput is what a --before or --after hook has written, kept in order to be shown along with the output
+// of its job
+type capturedOutput struct {
+	mutex  sync.Mutex
+	chunks []capturedChunk
+}
+
+type capturedChunk struct {
+	fd   int
+	data []byte
+}
+
+type capturedWriter struct {
+	output *capturedOutput
+	fd     int
+}
+
+func (writer capturedWriter) Write(data []byte) (int, error) {
+	writer.output.mutex.Lock()
+	defer writer.output.mutex.Unlock()
+
+	writer.output.chunks = append(writer.output.chunks, capturedChunk{fd: writer.fd, data: append([]byte{}, data...)})
+	return len(data), nil
+}
+
+func (captured *capturedOutput) writer(fd int) io.Writer {
+	return capturedWriter{output: captured, fd: fd}
+}
+
+// appendNotice adds a message from us, the same way Output.appendNotice does
+func (captured *capturedOutput) appendNotice(message string) {
+	_, _ = captured.writer(syscall.Stderr).Write([]byte(fmt.Sprintf("%s: %s\n", os.Args[0], message)))
+}
+
+func (captured *capturedOutput) appendTo(out *Output) {
+	for _, chunk := range captured.chunks {
+		waitIfUsingTooMuchMemory(chunkSizeWithHeader(chunk.data), out)
+		out.appendOrWrite(chunk.data, chunk.fd)
+	}
+}
+
+// runHook runs a --before or --after shell command of a job, in the same directory and with the same environment
+// as the job itself
+func runHook(job *Job, hook string, slot int, extraEnv ...string) (output *capturedOutput, err error) {
+	output = &capturedOutput{}
+	if hook == "" {
+		return output, nil
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", hook)
+	cmd.Dir = job.workDir
+	cmd.Env = append(job.environ(slot), extraEnv...)
+	cmd.Stdout = output.writer(syscall.Stdout)
+	cmd.Stderr = output.writer(syscall.Stderr)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	runAsChild(cmd)
+
+	return output, cmd.Run()
+}
+
+// runBeforeHook runs the --before hook of a job that's just about to start. If the job shouldn't be started because
+// of the hook failing, it returns the result the job should end with instead
+func runBeforeHook(job *Job, slot int) (hookOutput *capturedOutput, failed *ProcessResult) {
+	hookOutput, err := runHook(job, job.before, slot)
+	if err == nil {
+		return hookOutput, nil
+	}
+
+	if *flOnHookFailure == "warn" {
+		hookOutput.appendNotice(hookFailureMessage("--before", job, err))
+		return hookOutput, nil
+	}
+
+	failed = failedToStart(job, fmt.Errorf("its --before hook has failed: %w", err))
+	hookOutput.appendTo(failed.output)
+	return nil, failed
+}
+
+// runAfterHook runs the --after hook of a job that has just finished, returning the exit code the job should end with
+func runAfterHook(proc *ProcessResult, job *Job, exitCode int) int {
+	hookOutput, err := runHook(job, job.after, proc.slot, fmt.Sprintf("GPARALLEL_EXIT_CODE=%d", exitCode))
+	hookOutput.appendTo(proc.output)
+	if err == nil {
+		return exitCode
+	}
+
+	proc.output.appendNotice(hookFailureMessage("--after", job, err))
+	if *flOnHookFailure == "warn" || exitCode != 0 {
+		return exitCode
+	}
+	return 1
+}
+
+func hookFailureMessage(hook string, job *Job, err error) string {
+	return fmt.Sprintf("the %s hook of %s has failed: %v", hook, abbreviate(shellescape.QuoteCommand(job.command), 200), err)
+}
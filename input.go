@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// how the input read by --xargs-compat gets split, mirroring xargs
+var xargsInput = struct {
+	// split lines into words on blanks, respecting quotes and backslashes
+	splitWords bool
+	// strip blanks from the beginning of every line, like with xargs -I
+	trimLeadingBlanks bool
+	// how many non-empty lines make up the arguments of one command, or 0 for no limit
+	maxLines int
+}{}
+
+// argumentReader reads the items for commands out of -s or --arg-file input. Every call to next returns the items
+// of one record - a line, or with --null a NUL-terminated string
+type argumentReader struct {
+	reader  *bufio.Reader
+	records int
+}
+
+func newArgumentReader(input io.Reader) *argumentReader {
+	return &argumentReader{reader: bufio.NewReader(input)}
+}
+
+func (input *argumentReader) next() (items []string, err error) {
+	delimiter := byte('\n')
+	if *flNull {
+		delimiter = 0
+	}
+
+	record, err := input.readRecord(delimiter)
+	if err != nil && err != io.EOF {
+		log.Fatalf("Failed reading: %v\n", err)
+	}
+	if record != "" || err == nil {
+		input.records += 1
+	}
+	if *flMaxRecords > 0 && input.records > *flMaxRecords {
+		log.Fatalf("The input has more than --max-records %d records\n", *flMaxRecords)
+	}
+
+	switch {
+	case xargsInput.splitWords:
+		items = splitXargsWords(record)
+	case xargsInput.trimLeadingBlanks && strings.TrimLeft(record, " \t") != "":
+		items = []string{strings.TrimLeft(record, " \t")}
+	case !xargsInput.trimLeadingBlanks && record != "":
+		items = []string{record}
+	}
+
+	return items, err
+}
+
+// readRecord reads up to the delimiter, without it - but not more than --max-line-len of it
+func (input *argumentReader) readRecord(delimiter byte) (string, error) {
+	var record []byte
+	for {
+		slice, err := input.reader.ReadSlice(delimiter)
+		if err == nil {
+			slice = slice[:len(slice)-1]
+		}
+		if parsedFlMaxLineLen > 0 && int64(len(record)+len(slice)) > parsedFlMaxLineLen {
+			log.Fatalf("Record %d of the input is longer than --max-line-len %s\n", input.records+1, *flMaxLineLen)
+		}
+		record = append(record, slice...)
+		if err != bufio.ErrBufferFull {
+			return string(record), err
+		}
+	}
+}
+
+// splitXargsWords splits a line into words the way xargs does by default: on blanks, unless they're quoted with
+// single or double quotes, or escaped with a backslash
+func splitXargsWords(line string) (words []string) {
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	for i := 0; i < len(line); i++ {
+		char := rune(line[i])
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(char)
+		case char == '\'' || char == '"':
+			quote = char
+			inWord = true
+		case char == '\\' && i+1 < len(line):
+			i += 1
+			word.WriteByte(line[i])
+			inWord = true
+		case char == ' ' || char == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(char)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		log.Fatalf("Unmatched %c quote in the input: %s\n", quote, abbreviate(line, 200))
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// argumentBatcher groups consecutive items into the arguments of single commands, following --max-args
+type argumentBatcher struct {
+	command []string
+	batch   []string
+	records int
+}
+
+// add takes the items of one record, returning every batch of arguments that's ready for a command
+func (batcher *argumentBatcher) add(items []string) (ready [][]string) {
+	if len(items) == 0 {
+		return nil
+	}
+
+	if *flMaxArgs == 0 && len(batcher.batch) > 0 && !fitsCommandLine(batcher.command, append(slices.Clone(batcher.batch), items...)) {
+		ready = append(ready, batcher.finish()...)
+	}
+
+	batcher.batch = append(batcher.batch, items...)
+	batcher.records += 1
+
+	for *flMaxArgs > 0 && len(batcher.batch) >= *flMaxArgs {
+		ready = append(ready, batcher.batch[:*flMaxArgs])
+		batcher.batch = batcher.batch[*flMaxArgs:]
+		batcher.records = 0
+	}
+	if xargsInput.maxLines > 0 && batcher.records >= xargsInput.maxLines {
+		ready = append(ready, batcher.finish()...)
+	}
+
+	return ready
+}
+
+// finish returns whatever is left as the last batch
+func (batcher *argumentBatcher) finish() (ready [][]string) {
+	if len(batcher.batch) > 0 {
+		ready = append(ready, batcher.batch)
+	}
+	batcher.batch = nil
+	batcher.records = 0
+	return ready
+}
+
+// fitsCommandLine tells whether a command with the arguments leaves enough space for the environment when started,
+// using the same limit as xargs
+func fitsCommandLine(command []string, arguments []string) bool {
+	limit := 128 * 1024
+	if systemLimit := argMax(); systemLimit > 0 {
+		limit = min(systemLimit-2048, limit)
+	}
+	return commandLineSize(instantiateCommandString(slices.Clone(command), arguments), nil) <= limit
+}
+
+// batchArguments splits arguments given after ::: into the arguments of single commands
+func batchArguments(command []string, arguments []string) (batches [][]string) {
+	batcher := argumentBatcher{command: command}
+	for _, argument := range arguments {
+		batches = append(batches, batcher.add([]string{argument})...)
+	}
+	return append(batches, batcher.finish()...)
+}
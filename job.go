@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/alessio/shellescape"
+	"golang.org/x/exp/slices"
+)
+
+// Job is a single command to run, along with everything describing the surroundings it should be run in
+type Job struct {
+	command []string
+
+	// if stdin is an *os.File, the job owns it - it's closed after the job is started
+	stdin   io.Reader
+	workDir string
+	env     []string
+
+	// a file to open as stdin when starting the job, see --stdin-file
+	stdinFile string
+
+	// files to write stdout and stderr to instead of showing them, see --stdout and --stderr
+	stdoutFile string
+	stderrFile string
+	redirects  [3]*os.File
+
+	// shell commands to run before the job starts and after it finishes, see --before and --after
+	before string
+	after  string
+
+	// 1-based number of the job, in the order jobs are started
+	seq int
+
+	// how many jobs are there going to be in total, or 0 if we don't know that
+	total int
+
+	// the id of the job in the --persist file, or 0 if it isn't in one
+	journalId int
+
+	// which group the job belongs to, with --group-by
+	groupKey string
+
+	// how long the job is expected to take, with --sort-by-size or --sort-by-cost
+	cost float64
+
+	// jobs sharing the key run one after another, with --shard-by
+	shardKey string
+
+	// the runs of the same command this one gets compared with, with --diff-outputs
+	variants *variantGroup
+
+	// the arguments the command has been made with, for --cache-files
+	arguments []string
+
+	// the job gets skipped if this file exists, and it's created once the job succeeds - see --skip-if-exists
+	marker string
+
+	// the file locked for as long as the job runs, with --flock
+	lockPath string
+	lock     *os.File
+
+	// the directory the job gets for itself with --scratch
+	scratchDir string
+}
+
+var startedJobs = atomic.Int64{}
+
+// slots hands out the lowest free number in [1, -P] to every running job, for $GPARALLEL_SLOT
+var slots = struct {
+	mutex sync.Mutex
+	taken []bool
+}{}
+
+func acquireSlot() int {
+	slots.mutex.Lock()
+	defer slots.mutex.Unlock()
+
+	for i, taken := range slots.taken {
+		if !taken {
+			slots.taken[i] = true
+			debugf("scheduler", "took slot %d", i+1)
+			return i + 1
+		}
+	}
+
+	slots.taken = append(slots.taken, true)
+	debugf("scheduler", "took new slot %d", len(slots.taken))
+	return len(slots.taken)
+}
+
+func releaseSlot(slot int) {
+	slots.mutex.Lock()
+	defer slots.mutex.Unlock()
+
+	slots.taken[slot-1] = false
+	debugf("scheduler", "released slot %d", slot)
+}
+
+func newJob(commandTemplate []string, arguments ...string) *Job {
+	job := &Job{}
+	job.command = instantiateCommandString(slices.Clone(commandTemplate), arguments)
+	job.arguments = arguments
+
+	if *flWorkDir != "" {
+		job.workDir = instantiateString(*flWorkDir, arguments)
+	}
+
+	if *flStdinFile != "" {
+		job.stdinFile = instantiateString(*flStdinFile, arguments)
+	}
+	if *flStdout != "" {
+		job.stdoutFile = instantiateString(*flStdout, arguments)
+	}
+	if *flStderr != "" {
+		job.stderrFile = instantiateString(*flStderr, arguments)
+	}
+
+	for _, env := range *flEnv {
+		job.env = append(job.env, instantiateString(env, arguments))
+	}
+
+	if *flGroupBy != "" {
+		job.groupKey = groupKey(arguments)
+	}
+	if *flFlock != "" {
+		job.lockPath = instantiateString(*flFlock, arguments)
+	}
+	if *flSkipIfExists != "" {
+		job.marker = instantiateString(*flSkipIfExists, arguments)
+	}
+	if *flShardBy != "" {
+		job.shardKey = instantiateString(*flShardBy, arguments)
+	}
+
+	if *flSortBySize || *flSortByCost != "" {
+		job.cost = jobCost(job, arguments)
+	}
+
+	// hooks are shell commands, so the arguments get quoted for them
+	job.before = instantiateShellString(*flBefore, arguments)
+	job.after = instantiateShellString(*flAfter, arguments)
+
+	return job
+}
+
+// cleanEnvKept is what's kept of our environment with --clean-env, on top of --keep-env
+var cleanEnvKept = []string{"HOME", "LANG", "LOGNAME", "PATH", "SHELL", "TERM", "TMPDIR", "TZ", "USER"}
+
+// inheritedEnviron is the part of our own environment that children get
+func inheritedEnviron() (env []string) {
+	if !*flCleanEnv {
+		return os.Environ()
+	}
+
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		// our own variables are still needed for nested gparallels to share -P
+		if slices.Contains(cleanEnvKept, name) || slices.Contains(*flKeepEnv, name) || strings.HasPrefix(name, "_GPARALLEL_") {
+			env = append(env, variable)
+		}
+	}
+	return env
+}
+
+// terminalEnviron is what --term, --columns and --rows tell commands about their terminal
+func terminalEnviron() (env []string) {
+	if *flTerm != "" {
+		env = append(env, "TERM="+*flTerm)
+	}
+	if *flColumns > 0 {
+		env = append(env, fmt.Sprintf("COLUMNS=%d", *flColumns))
+	}
+	if *flRows > 0 {
+		env = append(env, fmt.Sprintf("LINES=%d", *flRows))
+	}
+	return env
+}
+
+// environ returns the full environment the job should be started with
+func (job *Job) environ(slot int) []string {
+	env := inheritedEnviron()
+	env = append(env, childUserEnviron()...)
+	env = append(env, terminalEnviron()...)
+	env = append(env, job.env...)
+	env = append(env,
+		fmt.Sprintf("GPARALLEL_SEQ=%d", job.seq),
+		fmt.Sprintf("GPARALLEL_SLOT=%d", slot))
+	if job.total > 0 {
+		env = append(env, fmt.Sprintf("GPARALLEL_TOTAL=%d", job.total))
+	}
+	if job.scratchDir != "" {
+		env = append(env, "GPARALLEL_TMPDIR="+job.scratchDir)
+	}
+	return env
+}
+
+// executedCommand is the command line that's going to be actually executed for the job
+func (job *Job) executedCommand() []string {
+	return withUnshareInit(chosenBackend().wrap(withRlimits(job.command)))
+}
+
+// instantiateString replaces every template placeholder in a string with the arguments, separated with spaces
+func instantiateString(template string, arguments []string) string {
+	if len(parsedFlTemplates) == 0 {
+		return template
+	}
+	return templateReplacer(arguments, func(arguments []string) string { return strings.Join(arguments, " ") }).Replace(template)
+}
+
+// instantiateShellString replaces every template placeholder in a shell command with the arguments quoted for the shell
+func instantiateShellString(template string, arguments []string) string {
+	if len(parsedFlTemplates) == 0 {
+		return template
+	}
+	return templateReplacer(arguments, shellescape.QuoteCommand).Replace(template)
+}
+
+// prepare sets up everything the job needs before it can be started, returning why it can't be if that's the case
+func (job *Job) prepare() (err error) {
+	if *flScratch {
+		if err := job.createScratch(); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				_ = os.RemoveAll(job.scratchDir)
+			}
+		}()
+	}
+
+	if err := checkCommandLineSize(job.executedCommand(), job.environ(0)); err != nil {
+		return err
+	}
+
+	if job.workDir != "" {
+		stat, err := os.Stat(job.workDir)
+		if errors.Is(err, fs.ErrNotExist) && *flWorkDirCreate {
+			if err := os.MkdirAll(job.workDir, fs.ModePerm); err != nil {
+				return fmt.Errorf("could not create working directory: %w", err)
+			}
+		} else if err != nil {
+			return fmt.Errorf("invalid working directory: %w", err)
+		} else if !stat.IsDir() {
+			return fmt.Errorf("working directory %s is not a directory", job.workDir)
+		}
+	}
+
+	if err := job.openRedirects(); err != nil {
+		job.closeRedirects()
+		return err
+	}
+
+	if job.stdinFile != "" {
+		file, err := os.Open(job.pathInWorkDir(job.stdinFile))
+		if err != nil {
+			job.closeRedirects()
+			return fmt.Errorf("could not open --stdin-file: %w", err)
+		}
+		job.stdin = file
+	}
+
+	return nil
+}
+
+// openRedirects opens the --stdout and --stderr files. When both are the same file, it's opened (and truncated) just
+// once, with stdout and stderr sharing where they write in it like they would with 2>&1
+func (job *Job) openRedirects() error {
+	stdoutPath, stderrPath := job.pathInWorkDir(job.stdoutFile), job.pathInWorkDir(job.stderrFile)
+
+	if job.stdoutFile != "" {
+		file, err := os.OpenFile(stdoutPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return fmt.Errorf("could not open a file for the output: %w", err)
+		}
+		job.redirects[syscall.Stdout] = file
+	}
+
+	if job.stderrFile == "" {
+		return nil
+	}
+	if job.stdoutFile != "" && filepath.Clean(stderrPath) == filepath.Clean(stdoutPath) {
+		// a duplicate, as the reader of each stream closes its file once done
+		fd, err := syscall.Dup(int(job.redirects[syscall.Stdout].Fd()))
+		if err != nil {
+			return fmt.Errorf("could not share the file for stdout with stderr: %w", err)
+		}
+		job.redirects[syscall.Stderr] = os.NewFile(uintptr(fd), stderrPath)
+		return nil
+	}
+
+	file, err := os.OpenFile(stderrPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("could not open a file for the output: %w", err)
+	}
+	job.redirects[syscall.Stderr] = file
+	return nil
+}
+
+// pathInWorkDir makes a relative path relative to the --wd of the job
+func (job *Job) pathInWorkDir(path string) string {
+	if job.workDir != "" && !filepath.IsAbs(path) {
+		return filepath.Join(job.workDir, path)
+	}
+	return path
+}
+
+// abandon releases everything prepared for a job that isn't going to be started after all
+func (job *Job) abandon() {
+	job.closeStdin()
+	job.closeRedirects()
+	job.releaseLock()
+	if job.scratchDir != "" {
+		_ = os.RemoveAll(job.scratchDir)
+	}
+}
+
+// closeRedirects closes the --stdout and --stderr files of a job that won't get to write to them
+func (job *Job) closeRedirects() {
+	for _, file := range job.redirects {
+		if file != nil {
+			_ = file.Close()
+		}
+	}
+}
+
+// closeStdin closes our copy of the stdin given to the job, so it's only held open by the job itself
+func (job *Job) closeStdin() {
+	if file, ok := job.stdin.(*os.File); ok {
+		haveToClose("stdin of a job", file)
+	}
+}
+
+// failedToStart reports a job that couldn't even be started as a finished one, failing with the reason as its output
+func failedToStart(job *Job, reason error) (result *ProcessResult) {
+	result = &ProcessResult{}
+	result.originalCommand = job.command
+	result.journalId = job.journalId
+	result.groupKey = job.groupKey
+	result.variants = job.variants
+	result.startedAt = time.Now()
+	result.finishedAt = result.startedAt
+	result.exitCode = make(chan int, 1)
+	result.finished = make(chan struct{})
+
+	job.closeStdin()
+	job.releaseLock()
+
+	result.output = &Output{}
+	if job.variants != nil {
+		result.output.collectedStdout = &bytes.Buffer{}
+	}
+	result.output.appendNotice(fmt.Sprintf("Could not start %s: %v", abbreviate(shellescape.QuoteCommand(job.command), 200), reason))
+
+	result.finish(1)
+
+	return result
+}
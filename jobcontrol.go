@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alessio/shellescape"
+)
+
+// With stdin being forwarded to the foreground command, --control-key followed by another key controls that
+// command instead: k terminates it, s skips to the next one (showing the rest of its output at the end), p pauses
+// or resumes starting new commands, and pressing --control-key twice passes it on.
+
+// the --control-key byte, 0 if there's none
+var parsedControlKey byte
+
+// skipRequests tells the display to move on from the command in the foreground
+var skipRequests = make(chan struct{}, 1)
+
+func controlKeyFromFlag() {
+	key := *flControlKey
+	switch {
+	case key == "":
+	case len(key) == 2 && key[0] == '^' && key[1] >= '@' && key[1] <= '_':
+		parsedControlKey = key[1] & 0x1f
+	case len(key) == 2 && key[0] == '^' && key[1] >= 'a' && key[1] <= 'z':
+		parsedControlKey = key[1] & 0x1f
+	case len(key) == 1:
+		parsedControlKey = key[0]
+	default:
+		errorWithUsage("Invalid value of the --control-key flag: expected a single key like ^] or ^G, but got '%s'", key)
+	}
+	if parsedControlKey == suspendCharacter {
+		errorWithUsage("--control-key cannot be ^Z, as that suspends everything")
+	}
+}
+
+// controlForeground does what the key pressed after --control-key asks for
+func controlForeground(key byte) {
+	switch key {
+	case parsedControlKey:
+		forwardToForeground([]byte{key})
+	case 'k':
+		running.mutex.Lock()
+		defer running.mutex.Unlock()
+		if running.foreground != nil {
+			terminate(running.foreground)
+		}
+	case 'p':
+		togglePause()
+	case 's':
+		select {
+		case skipRequests <- struct{}{}:
+		default:
+		}
+	default:
+		_, _ = os.Stderr.WriteString("\a")
+	}
+}
+
+// backToBackground makes the output of a command that's been in the foreground get stored again, to be shown later
+func (out *Output) backToBackground() {
+	out.partsMutex.Lock()
+	out.shouldPassToParent = false
+	// the previous one has been closed after writing out what it had stored
+	out.allocator = chunkAllocator{}
+	out.partsMutex.Unlock()
+
+	mem.childDiedFreeingMemory.L.Lock()
+	defer mem.childDiedFreeingMemory.L.Unlock()
+	if mem.currentlyInTheForeground == out {
+		mem.currentlyInTheForeground = nil
+	}
+}
+
+func announceSkipped(proc *ProcessResult) {
+	announce(fmt.Sprintf("%s: skipped %s, showing the rest of its output at the end",
+		os.Args[0], abbreviate(shellescape.QuoteCommand(proc.originalCommand), 200)))
+}
+
+// announce tells about a change made with --control-key between the output of commands
+func announce(message string) {
+	// stdin, and so the terminal, may be in raw mode - a newline alone doesn't go back to the first column
+	_, _ = fmt.Fprintf(os.Stderr, "\r\n%s\r\n", yellow(message))
+}
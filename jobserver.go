@@ -0,0 +1,139 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// makeJobserver is a client of the jobserver of GNU make we've been run by, so that the commands we run count
+// towards the -j of make. Make has already taken a token for us, which lets us always run one child - every
+// other one needs a token read from the jobserver, which is written back once it exits
+type makeJobserver struct {
+	read  *os.File
+	write *os.File
+
+	mutex             sync.Mutex
+	implicitTokenUsed bool
+}
+
+// jobserverToken is what's been taken from the jobserver to run a child
+type jobserverToken struct {
+	taken    bool
+	implicit bool
+	value    byte
+}
+
+var jobserver = onceValue(func() *makeJobserver {
+	if !*flJobserver {
+		return nil
+	}
+	return findMakeJobserver(os.Getenv("MAKEFLAGS"))
+})
+
+// findMakeJobserver looks for the jobserver in MAKEFLAGS, which is either a named pipe (--jobserver-auth=fifo:PATH
+// since make 4.4), or a pair of inherited file descriptors (--jobserver-auth=R,W, or --jobserver-fds=R,W before 4.2)
+func findMakeJobserver(makeflags string) *makeJobserver {
+	var auth string
+	for _, word := range strings.Fields(makeflags) {
+		if strings.HasPrefix(word, "--jobserver-auth=") {
+			auth = strings.TrimPrefix(word, "--jobserver-auth=")
+		} else if strings.HasPrefix(word, "--jobserver-fds=") {
+			auth = strings.TrimPrefix(word, "--jobserver-fds=")
+		}
+	}
+	if auth == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(auth, "fifo:") {
+		fifo, err := os.OpenFile(strings.TrimPrefix(auth, "fifo:"), os.O_RDWR, 0)
+		if err != nil {
+			log.Printf("Warning: could not open the jobserver of make, not sharing -j with it: %v\n", err)
+			return nil
+		}
+		return &makeJobserver{read: fifo, write: fifo}
+	}
+
+	readFd, writeFd, _ := strings.Cut(auth, ",")
+	read, readErr := strconv.Atoi(readFd)
+	write, writeErr := strconv.Atoi(writeFd)
+	if readErr != nil || writeErr != nil || read < 0 || write < 0 {
+		log.Printf("Warning: could not understand the jobserver of make, not sharing -j with it: '%s'\n", auth)
+		return nil
+	}
+
+	// make only passes the descriptors on to recipes it knows to be recursive - otherwise they're closed, or
+	// worse, reused for something else
+	for _, fd := range []int{read, write} {
+		if _, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0); err != nil {
+			return nil
+		}
+	}
+	unix.CloseOnExec(read)
+	unix.CloseOnExec(write)
+
+	return &makeJobserver{
+		read:  os.NewFile(uintptr(read), "make jobserver (read end)"),
+		write: os.NewFile(uintptr(write), "make jobserver (write end)"),
+	}
+}
+
+// acquire waits for a token to run a child with. As that can take a while when make keeps every token busy, it
+// gives up when we stop spawning new children
+func (server *makeJobserver) acquire() (token jobserverToken, ok bool) {
+	server.mutex.Lock()
+	if !server.implicitTokenUsed {
+		server.implicitTokenUsed = true
+		server.mutex.Unlock()
+		return jobserverToken{taken: true, implicit: true}, true
+	}
+	server.mutex.Unlock()
+
+	tokens := make(chan jobserverToken)
+	cancel := make(chan struct{})
+	go func() {
+		var buf [1]byte
+		if _, err := server.read.Read(buf[:]); err != nil {
+			log.Fatalf("Could not get a token from the jobserver of make: %v\n", err)
+		}
+
+		token := jobserverToken{taken: true, value: buf[0]}
+		select {
+		case tokens <- token:
+		case <-cancel:
+			// nobody wants it anymore - don't let make lose it
+			server.release(token)
+		}
+	}()
+
+	select {
+	case token := <-tokens:
+		return token, true
+	case <-spawning.Done():
+		close(cancel)
+		return jobserverToken{}, false
+	}
+}
+
+// release gives a token back after the child it was taken for has exited
+func (server *makeJobserver) release(token jobserverToken) {
+	if !token.taken {
+		return
+	}
+
+	if token.implicit {
+		server.mutex.Lock()
+		server.implicitTokenUsed = false
+		server.mutex.Unlock()
+		return
+	}
+
+	if _, err := server.write.Write([]byte{token.value}); err != nil {
+		log.Printf("Warning: could not give a token back to the jobserver of make: %v\n", err)
+	}
+}
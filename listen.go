@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/karolba/gparallel/chann"
+	"golang.org/x/exp/slices"
+)
+
+// submission is what --submit sends to a gparallel started with --listen, as a single line of JSON
+type submission struct {
+	// a whole command to run
+	Command []string `json:",omitempty"`
+
+	// arguments for the command the listening gparallel has been given
+	Arguments []string `json:",omitempty"`
+
+	// no more submissions are going to come
+	End bool `json:",omitempty"`
+}
+
+// jobs that came through the --listen socket, in the order they came in
+var submissions = struct {
+	mutex sync.Mutex
+	ended bool
+	jobs  *chann.Chann[*Job]
+}{
+	jobs: chann.New[*Job](),
+}
+
+func endSubmissions() {
+	submissions.mutex.Lock()
+	defer submissions.mutex.Unlock()
+
+	if !submissions.ended {
+		submissions.ended = true
+		submissions.jobs.Close()
+	}
+}
+
+// listenForSubmissions creates the --listen socket, taking over one left behind by a gparallel that's no longer
+// running - but not one that still is
+func listenForSubmissions(args Args) (stop func()) {
+	if conn, err := net.Dial("unix", *flListen); err == nil {
+		_ = conn.Close()
+		log.Fatalf("Another gparallel is already listening on %s\n", *flListen)
+	}
+	_ = os.Remove(*flListen)
+
+	listener, err := net.Listen("unix", *flListen)
+	if err != nil {
+		log.Fatalf("Couldn't listen on unix socket '%s': %v\n", *flListen, err)
+	}
+
+	if *flPersist != "" {
+		for _, job := range openJournal(args) {
+			submissions.jobs.In() <- job
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				log.Fatalf("Error accepting connection on the --listen socket: %v\n", err)
+			}
+			go receiveSubmission(conn, args)
+		}
+	}()
+
+	// after a failure or an interrupt nothing new is going to be started, so stop waiting for more
+	go func() {
+		<-spawning.Done()
+		endSubmissions()
+	}()
+
+	return func() {
+		_ = listener.Close()
+	}
+}
+
+func receiveSubmission(conn net.Conn, args Args) {
+	defer haveToClose("connection from --submit", conn)
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return
+	}
+
+	reply := "ok"
+	if err := acceptSubmission(line, args); err != nil {
+		reply = err.Error()
+	}
+	_, _ = fmt.Fprintln(conn, reply)
+}
+
+func acceptSubmission(line []byte, args Args) error {
+	var sub submission
+	if err := json.Unmarshal(line, &sub); err != nil {
+		return fmt.Errorf("could not parse the submission: %w", err)
+	}
+
+	submissions.mutex.Lock()
+	defer submissions.mutex.Unlock()
+
+	if submissions.ended {
+		return errors.New("not accepting any more submissions")
+	}
+
+	switch {
+	case sub.End:
+		submissions.ended = true
+		submissions.jobs.Close()
+	case len(sub.Command) > 0:
+		job := &Job{command: sub.Command}
+		persistJob(job, nil)
+		submissions.jobs.In() <- job
+	case len(sub.Arguments) > 0 && len(args.command) == 0:
+		return errors.New("there is no command to pass the arguments to, only whole commands can be submitted")
+	case len(sub.Arguments) > 0:
+		for _, arguments := range batchArguments(args.command, sub.Arguments) {
+			job := newJob(args.command, arguments...)
+			persistJob(job, arguments)
+			submissions.jobs.In() <- job
+		}
+	default:
+		return errors.New("nothing has been submitted")
+	}
+
+	return nil
+}
+
+// submit implements --submit: a command, arguments after :::, or nothing at all to tell there's nothing more
+func submit(args []string) {
+	var sub submission
+	switch {
+	case len(args) == 0:
+		sub.End = true
+	case args[0] == ":::":
+		sub.Arguments = args[1:]
+	case slices.Contains(args, ":::"):
+		log.Fatalf("Either a command or arguments after \":::\" can be submitted, not both\n")
+	default:
+		sub.Command = args
+	}
+
+	encoded, err := json.Marshal(sub)
+	if err != nil {
+		log.Fatalf("Could not encode the submission: %v\n", err)
+	}
+
+	conn, err := net.Dial("unix", *flSubmit)
+	if err != nil {
+		log.Fatalf("Could not connect to a gparallel listening on %s: %v\n", *flSubmit, err)
+	}
+	defer haveToClose("connection to --listen", conn)
+
+	if _, err := conn.Write(append(encoded, '\n')); err != nil {
+		log.Fatalf("Could not submit: %v\n", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		log.Fatalf("Could not get a reply for the submission: %v\n", err)
+	}
+	if reply = strings.TrimSuffix(reply, "\n"); reply != "ok" {
+		log.Fatalf("Submission rejected: %s\n", reply)
+	}
+}
@@ -1,16 +1,14 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/exec"
-	"os/signal"
 	"runtime/debug"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -18,8 +16,8 @@ import (
 	"github.com/alessio/shellescape"
 	"github.com/fatih/color"
 	"github.com/karolba/gparallel/chann"
-	"github.com/pkg/term/termios"
 	"golang.org/x/exp/slices"
+	"golang.org/x/sys/unix"
 	"golang.org/x/term"
 )
 
@@ -29,14 +27,63 @@ var standardFdToFile = []*os.File{
 	2: os.Stderr,
 }
 
-var noLongerSpawnChildren = atomic.Bool{}
+// executing gets cancelled when the run has to end right away - on the third ^C, or on another SIGTERM/SIGHUP while
+// shutting down. Output the children have written but that hasn't been shown yet gets dropped then: their readers
+// stop storing it, nothing more gets shown, and displaySequentially returns the exitStatus it's been cancelled with
+var executing, abort = context.WithCancelCause(context.Background())
+
+// spawning gets cancelled once no more children should be started - after a failed one, on ^C or on SIGTERM/SIGHUP.
+// Children already running are left alone by that: whoever cancels it decides whether they get stopped, and whether
+// the output they have collected still gets shown (see waitForChildrenAfterAFailedOne and handleShutdown)
+var spawning, stopSpawning = context.WithCancel(executing)
+
+// exitStatus is the cause executing gets cancelled with
+type exitStatus int
+
+func (status exitStatus) Error() string {
+	return fmt.Sprintf("aborted with exit status %d", int(status))
+}
+
+// abortedExitCode is what to exit with after executing has been cancelled
+func abortedExitCode() int {
+	var status exitStatus
+	if errors.As(context.Cause(executing), &status) {
+		return int(status)
+	}
+	return 1
+}
 
 var bold = color.New(color.Bold).SprintFunc()
 var yellow = color.New(color.FgYellow).SprintFunc()
 
+// verbosef logs a message if -v has been given at least level times
+func verbosef(level int, format string, args ...any) {
+	if *flVerbose >= level {
+		log.Printf(format+"\n", args...)
+	}
+}
+
+// how much output has been written out since we've last asked Go to return memory to the OS
+var freedSinceFreeingOSMemory int64
+
 func writeOut(out *Output) {
 	var clearedOutBytes int64
 
+	// write runs of chunks going to the same fd all at once - but never reorder them, as stdout and stderr might
+	// very well end up in the same terminal
+	var pending [][]byte
+	var pendingFd byte
+	flush := func() {
+		if out.collectsStdout(int(pendingFd)) {
+			for _, content := range pending {
+				out.collect(content)
+			}
+		} else {
+			_ = writeBuffers(standardFdToFile[pendingFd], pending)
+		}
+		pending = pending[:0]
+	}
+
 	offset := 0
 	for {
 		fd, content, ok := out.getNextChunk(&offset)
@@ -44,17 +91,38 @@ func writeOut(out *Output) {
 			break
 		}
 
-		_, _ = standardFdToFile[fd].Write(content)
+		if fd != pendingFd && len(pending) > 0 {
+			flush()
+		}
+		pendingFd = fd
+
+		out.termModes.observe(int(fd), content)
+		pending = append(pending, content)
 
 		clearedOutBytes += chunkSizeWithHeader(content)
 	}
+	if len(pending) > 0 {
+		flush()
+	}
+
+	releaseOutput(out, clearedOutBytes)
+}
 
+// releaseOutput frees everything an output has stored after it's been written out, making it the one in the
+// foreground
+func releaseOutput(out *Output, clearedOutBytes int64) {
 	out.allocator.mustFree(out.parts)
 	out.allocator.mustClose()
 	out.parts = nil
 
-	// Just deallocated a lot due to a child process dying, let's also hint Go to do the same
-	debug.FreeOSMemory()
+	// Just deallocated a lot due to a child process dying, let's also hint Go to do the same. But not after every
+	// single child, as that's a whole garbage collection cycle every time
+	freedSinceFreeingOSMemory += clearedOutBytes
+	if freedSinceFreeingOSMemory >= parsedFlFreeOSMemoryEvery {
+		debugf("memory", "returning memory to the OS after %s of output has been written out", mebibytes(freedSinceFreeingOSMemory))
+		debug.FreeOSMemory()
+		freedSinceFreeingOSMemory = 0
+	}
 
 	mem.childDiedFreeingMemory.L.Lock()
 	defer mem.childDiedFreeingMemory.L.Unlock()
@@ -64,13 +132,37 @@ func writeOut(out *Output) {
 	mem.childDiedFreeingMemory.Broadcast()
 }
 
-func toForeground(proc *ProcessResult) (exitCode int) {
+func toForeground(proc *ProcessResult) (exitCode int, skipped bool) {
+	setForeground(proc)
+	titleShowsCommand(proc)
+
 	proc.output.partsMutex.Lock()
+	showingOutput(proc.output)
+	if proc.output.alerts != nil {
+		proc.output.alerts.release(proc.output)
+	}
 	writeOut(proc.output)
+	flushSidebandAfter(proc.output)
 	proc.output.shouldPassToParent = true
 	proc.output.partsMutex.Unlock()
 
-	return <-proc.exitCode // block until the process exits
+	// a skip asked for while showing the previous command is too late to apply to this one
+	select {
+	case <-skipRequests:
+	default:
+	}
+
+	// block until the process exits, unless asked to move on with --control-key
+	select {
+	case exitCode = <-proc.exitCode:
+		return exitCode, false
+	case <-skipRequests:
+		setForeground(nil)
+		proc.output.backToBackground()
+		return 0, true
+	case <-executing.Done():
+		return 0, false
+	}
 }
 
 func tryToIncreaseNoFile() {
@@ -84,49 +176,53 @@ func tryToIncreaseNoFile() {
 }
 
 func waitForChildrenAfterAFailedOne(processes <-chan *ProcessResult) {
-	wg := sync.WaitGroup{}
-
+	var terminated []*ProcessResult
 	for processResult := range processes {
-		processResult := processResult
-
-		_ = processResult.cmd.Process.Signal(syscall.SIGTERM)
-
-		wg.Add(1)
-		go func() {
-			<-processResult.exitCode
-			wg.Done()
-		}()
+		terminate(processResult)
+		terminated = append(terminated, processResult)
 	}
 
-	wg.Wait()
+	for _, processResult := range terminated {
+		<-processResult.finished
+	}
 }
 
-func instantiateCommandString(command []string, argument string) []string {
-	if *flTemplate == "" {
-		return append(command, argument)
+func instantiateCommandString(command []string, arguments []string) []string {
+	if len(parsedFlTemplates) == 0 {
+		return append(command, arguments...)
 	}
 
+	replacer := templateReplacer(arguments, func(arguments []string) string { return strings.Join(arguments, " ") })
 	replacedIn := 0
+	instantiated := make([]string, 0, len(command))
 
-	for i, word := range command {
-		if !strings.Contains(word, *flTemplate) {
+	for _, word := range command {
+		if !containsTemplate(word) {
+			instantiated = append(instantiated, word)
 			continue
 		}
 
-		command[i] = strings.ReplaceAll(command[i], *flTemplate, argument)
+		if i := slices.Index(parsedFlTemplates, word); i != -1 {
+			// every argument becomes a separate word
+			instantiated = append(instantiated, argumentsOfTemplate(arguments, i)...)
+		} else {
+			instantiated = append(instantiated, replacer.Replace(word))
+		}
 		replacedIn += 1
 	}
 
 	if replacedIn == 0 {
-		// If there's no {}-template anywhere, let's just append the argument at the end
-		return append(command, argument)
+		// If there's no {}-template anywhere, let's just append the arguments at the end
+		return append(command, arguments...)
 	} else {
-		return command
+		return instantiated
 	}
 }
 
 func resetTermStateBeforeExit(originalTermState *term.State) {
+	restoreTitle()
 	if originalTermState != nil {
+		debugf("term", "restoring the terminal state before exiting")
 		err := term.Restore(syscall.Stdout, originalTermState)
 		if err != nil {
 			log.Printf("Warning: could not restore terminal state on exit: %v\n", err)
@@ -134,36 +230,44 @@ func resetTermStateBeforeExit(originalTermState *term.State) {
 	}
 }
 
-func startProcessesFromCliArguments(args Args, result chan<- *ProcessResult) {
-	for _, argument := range args.data {
-		if noLongerSpawnChildren.Load() {
-			break
-		}
+// spawn starts a job and passes it on to be displayed - unless we've stopped starting new ones in the meantime
+func spawn(result chan<- *ProcessResult, job *Job) *ProcessResult {
+	if *flDryRun {
+		_, _ = fmt.Println(shellescape.QuoteCommand(job.command))
+		job.closeStdin()
+		return nil
+	}
+	if *flExplain {
+		explainJob(job)
+		return nil
+	}
 
-		result <- run(instantiateCommandString(slices.Clone(args.command), argument))
+	processResult := runJob(executing, job)
+	titleSawJob(job, processResult)
+	if processResult != nil {
+		addBuffering(processResult)
+		waitingToBeShown.Add(1)
+		result <- processResult
 	}
+	return processResult
 }
 
-func startProcessesFromStdin(args Args, result chan<- *ProcessResult) {
-	stdinReader := bufio.NewReader(os.Stdin)
+// how many commands have been started, but haven't been taken to be shown yet
+var waitingToBeShown atomic.Int32
 
-	for {
-		line, err := stdinReader.ReadString('\n')
-		line = strings.TrimSuffix(line, "\n")
-
-		if noLongerSpawnChildren.Load() {
-			break
-		}
-		if len(line) > 0 {
-			result <- run(instantiateCommandString(slices.Clone(args.command), line))
-		}
+// queueStatus tells how many commands are already waiting behind the one about to be shown, for -v
+func queueStatus(proc *ProcessResult, skipped int) string {
+	behind := int(waitingToBeShown.Load()) + skipped
+	if behind == 0 {
+		return ""
+	}
 
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			log.Fatalf("Failed reading: %v\n", err)
-		}
+	stillRunning := runningCount()
+	if proc.isAlive() {
+		stillRunning--
 	}
+	stillRunning = max(0, min(stillRunning, behind))
+	return yellow(fmt.Sprintf(" [%d finished and buffered, %d still running behind this one]", behind-stillRunning, stillRunning))
 }
 
 func displaySequentially(processes <-chan *ProcessResult) (exitCode int) {
@@ -179,45 +283,134 @@ func displaySequentially(processes <-chan *ProcessResult) (exitCode int) {
 		}
 	}
 
+	var originalStdinState *term.State
+	if shouldForwardStdin() {
+		originalStdinState = startForwardingStdin()
+		defer restoreStdinState(originalStdinState)
+	}
+
+	restoreTerminal := func() {
+		restoreStdinState(originalStdinState)
+		resetTermStateBeforeExit(originalTermState)
+	}
+	handleInterrupts(restoreTerminal)
+	handleShutdown(restoreTerminal)
+	handleSuspend(originalStdinState)
+	handlePause()
+	enforceDeadline()
+
 	if originalTermState != nil {
 		defer resetTermStateBeforeExit(originalTermState)
+	}
 
-		signalledToExit := make(chan os.Signal, 1)
-		signal.Notify(signalledToExit, syscall.SIGINT, syscall.SIGTERM)
-		go func() {
-			<-signalledToExit
-			resetTermStateBeforeExit(originalTermState)
-			os.Exit(1)
-		}()
+	// commands skipped with --control-key get shown again after everything else
+	var skipped []*ProcessResult
+	next := func() (*ProcessResult, bool) {
+		if executing.Err() != nil {
+			return nil, false
+		}
+		select {
+		case processResult, ok := <-processes:
+			if ok {
+				waitingToBeShown.Add(-1)
+				return processResult, true
+			}
+		case <-executing.Done():
+			return nil, false
+		}
+		if len(skipped) == 0 {
+			return nil, false
+		}
+		processResult := skipped[0]
+		skipped = skipped[1:]
+		return processResult, true
 	}
 
 	firstProcess := true
-	for processResult := range processes {
-		if *flVerbose {
+	variantsDiffered := false
+	for processResult, ok := next(); ok; processResult, ok = next() {
+		if *flRace && lostRace(processResult) {
+			discardOutput(processResult)
+			continue
+		}
+		if winner := succeededFirst(); winner != nil && winner != processResult {
+			// only what has already been shown of the others stays
+			discardOutput(processResult)
+			continue
+		}
+
+		if *flGroupBy != "" {
+			showGroupHeader(processResult)
+		}
+
+		if *flVerbose >= 1 {
 			quotedCommand := shellescape.QuoteCommand(processResult.originalCommand)
+			status := queueStatus(processResult, len(skipped))
 
 			if firstProcess || !stdoutIsTty() {
-				_, _ = fmt.Fprintf(os.Stderr, bold("+ %s")+"\n", quotedCommand)
+				_, _ = fmt.Fprintf(os.Stderr, bold("+ %s")+"%s\n", quotedCommand, status)
 			} else if !processResult.isAlive() {
 				_, _ = fmt.Fprintf(os.Stderr,
-					bold("+ %s")+yellow(" (already finished, reporting saved output)")+"\n",
-					quotedCommand)
+					bold("+ %s")+yellow(" (already finished, reporting saved output)")+"%s\n",
+					quotedCommand, status)
 			} else if -time.Until(processResult.startedAt) > 1*time.Second {
 				_, _ = fmt.Fprintf(os.Stderr,
-					bold("+ %s")+yellow(" (resumed output, already runnning for %v)")+"\n",
+					bold("+ %s")+yellow(" (resumed output, already runnning for %v)")+"%s\n",
 					quotedCommand,
-					-time.Until(processResult.startedAt).Round(time.Second))
+					-time.Until(processResult.startedAt).Round(time.Second),
+					status)
 			} else {
-				_, _ = fmt.Fprintf(os.Stderr, bold("+ %s")+"\n", quotedCommand)
+				_, _ = fmt.Fprintf(os.Stderr, bold("+ %s")+"%s\n", quotedCommand, status)
 			}
 		}
 
-		exitCode = max(exitCode, toForeground(processResult))
+		attachStdin(processResult.output)
+		processExitCode, wasSkipped := toForeground(processResult)
+		if executing.Err() != nil {
+			detachStdin()
+			restoreTerminalModes(processResult.output)
+			break
+		}
+		if wasSkipped {
+			detachStdin()
+			restoreTerminalModes(processResult.output)
+			announceSkipped(processResult)
+			skipped = append(skipped, processResult)
+			firstProcess = false
+			continue
+		}
+		removeBuffering(processResult)
+		exitCode = max(exitCode, processExitCode)
+		detachStdin()
+		restoreTerminalModes(processResult.output)
+		recordFinishedJob(processResult, processExitCode)
+		if *flChecksum {
+			printChecksum(processResult)
+		}
+		if processResult.variants != nil && recordVariant(processResult, processExitCode) {
+			variantsDiffered = true
+		}
+		if processExitCode == 0 || (shutdownSignal.Load() == 0 && !deadlineExceeded.Load()) {
+			// whatever we've interrupted by shutting down should run again when we're back
+			markPersistedJobDone(processResult)
+		}
+
+		if *flVerbose >= 1 && processResult.killedBy != 0 {
+			_, _ = fmt.Fprintf(os.Stderr, yellow("%s: %s was killed by %s")+"\n",
+				os.Args[0],
+				shellescape.QuoteCommand(processResult.originalCommand),
+				unix.SignalName(processResult.killedBy))
+		}
 
-		if !*flKeepGoingOnError {
+		// when shutting down, every child is going to fail - keep going to still show everything they've written
+		if !*flKeepGoingOnError && !*flHaltOnSuccess && shutdownSignal.Load() == 0 && !deadlineExceeded.Load() {
 			if exitCode != 0 {
-				noLongerSpawnChildren.Store(true)
+				stopSpawning()
 
+				for _, skippedProcess := range skipped {
+					terminate(skippedProcess)
+					<-skippedProcess.finished
+				}
 				waitForChildrenAfterAFailedOne(processes)
 				break
 			}
@@ -226,6 +419,29 @@ func displaySequentially(processes <-chan *ProcessResult) (exitCode int) {
 		firstProcess = false
 	}
 
+	if executing.Err() != nil {
+		return abortedExitCode()
+	}
+
+	if *flSummary {
+		printSummary()
+	}
+
+	if variantsDiffered {
+		exitCode = max(exitCode, 1)
+	}
+	if *flHaltOnSuccess && succeededFirst() != nil {
+		// the others failing is what's expected when trying them until one works
+		exitCode = 0
+	}
+
+	if sig := shutdownSignal.Load(); sig != 0 {
+		return 128 + int(sig)
+	}
+	if deadlineExceeded.Load() {
+		return deadlineExitCode
+	}
+
 	return exitCode
 }
 
@@ -242,6 +458,8 @@ func executeAndFlushTty(command []string) (exitCode int) {
 		log.Fatalf("Could not find executable %s: %v\n", command[0], err)
 	}
 
+	ignoreGroupSignals()
+
 	process, err := os.StartProcess(path, command, &os.ProcAttr{
 		Files: standardFdToFile,
 	})
@@ -259,8 +477,12 @@ func executeAndFlushTty(command []string) (exitCode int) {
 		log.Fatalf("Could not wait for process %v, %v\n", shellescape.QuoteCommand(command), err)
 	}
 
-	_ = termios.Tcdrain(uintptr(syscall.Stdout))
-	_ = termios.Tcdrain(uintptr(syscall.Stderr))
+	_ = tcdrain(syscall.Stdout)
+	_ = tcdrain(syscall.Stderr)
+
+	if status, ok := processState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		dieBySignal(status.Signal())
+	}
 
 	return processState.ExitCode()
 }
@@ -274,6 +496,14 @@ func main() {
 	switch {
 	case *flExecuteAndFlushTty:
 		os.Exit(executeAndFlushTty(args.command))
+	case *flRlimitExec != "":
+		os.Exit(executeWithRlimits(*flRlimitExec, args.command))
+	case *flTmuxJob != "":
+		os.Exit(runTmuxJob(*flTmuxJob, args.command))
+	case *flTmuxWindow != "":
+		os.Exit(runTmuxWindow(*flTmuxWindow, args.command))
+	case *flUnshareInit != "":
+		os.Exit(runUnshareInit(*flUnshareInit, args.command))
 	case *flQueueCommandAncestor != "":
 		queueCommandForAncestor(args.command, *flQueueCommandAncestor)
 		os.Exit(0)
@@ -286,8 +516,20 @@ func main() {
 	case *flShowQueue:
 		showGlobalQueue()
 		os.Exit(0)
+	case *flSubmit != "":
+		submit(args.command)
+		os.Exit(0)
 	}
 
+	if watchExitCodesPath == "" {
+		validateTemplate(args.command)
+	}
+
+	if *flWatch > 0 && watchExitCodesPath == "" {
+		os.Exit(watch())
+	}
+	_ = os.Unsetenv(EnvGparallelWatchExitCodes)
+
 	if !*flRecursiveProcessLimit {
 		_ = os.Unsetenv(EnvGparallelChildLimitSocket)
 	}
@@ -295,22 +537,64 @@ func main() {
 		createLimitServer()
 	}
 
+	if *flTmux && !*flDryRun && !*flExplain {
+		startTmuxSession()
+	}
+
+	stopProfiling := startProfiling()
+	startDebuggingMemory()
+
+	stopListening := func() {}
+	if *flListen != "" {
+		stopListening = listenForSubmissions(args)
+	}
+
+	pledge()
+
+	addFinishListeners()
+	startSettingTitle()
+
 	processes := chann.New[*ProcessResult]()
 	go func() {
 		defer processes.Close()
+		defer finishExplaining()
 
 		if *flQueueWait {
 			startProcessesFromQueue(processes.In())
 			return
 		}
 
+		if *flCombine == combineZip || *flCombine == combineProduct {
+			startProcessesCombined(args, processes.In())
+			return
+		}
+
 		if args.hasTripleColon {
-			startProcessesFromCliArguments(args, processes.In())
+			startProcessesFrom(withRunIfEmpty(args.command, newCliArgumentsSource(args)), processes.In())
 		}
 		if *flFromStdin {
-			startProcessesFromStdin(args, processes.In())
+			startProcessesFrom(withRunIfEmpty(args.command, newLinesSource(args.command, os.Stdin)), processes.In())
+		}
+		if *flArgFile != "" {
+			startProcessesFromArgFile(args, processes.In())
+		}
+		if *flListen != "" {
+			startProcessesFrom(submissionsSource{}, processes.In())
 		}
 	}()
 
-	os.Exit(displaySequentially(processes.Out()))
+	exitCode := displaySequentially(processes.Out())
+	if *flFailIfEmpty && !gotAnyInput.Load() {
+		log.Printf("No arguments to run the command with\n")
+		exitCode = emptyInputExitCode
+	}
+	if watchExitCodesPath != "" {
+		saveExitCodesForWatch()
+	}
+	if len(*flNotify) > 0 {
+		notifyFinished(args.command, exitCode)
+	}
+	stopListening()
+	stopProfiling()
+	os.Exit(exitCode)
 }
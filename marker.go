@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+
+	"github.com/alessio/shellescape"
+)
+
+// markerExists tells whether the --skip-if-exists marker of a job is there, meaning it has already succeeded before
+func (job *Job) markerExists() bool {
+	if job.marker == "" {
+		return false
+	}
+
+	_, err := os.Stat(job.pathInWorkDir(job.marker))
+	if err != nil {
+		return false
+	}
+	verbosef(2, "Skipping %s, as %s exists", abbreviate(shellescape.QuoteCommand(job.command), 200), job.marker)
+	return true
+}
+
+// createMarker creates the --skip-if-exists marker of a job that has succeeded, so that it doesn't run again
+func (job *Job) createMarker(exitCode int, out *Output) {
+	if job.marker == "" || exitCode != 0 {
+		return
+	}
+
+	file, err := os.OpenFile(job.pathInWorkDir(job.marker), os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		out.appendNotice("Could not create the --skip-if-exists marker: " + err.Error())
+		return
+	}
+	_ = file.Close()
+}
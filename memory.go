@@ -6,8 +6,6 @@ import (
 	"sync"
 	"sync/atomic"
 	"unsafe"
-
-	"modernc.org/memory"
 )
 
 // make sure we don't use too much RAM for storing command output
@@ -15,16 +13,20 @@ var mem = struct {
 	childDiedFreeingMemory   *sync.Cond
 	currentlyInTheForeground *Output
 	currentlyStored          atomic.Int64
+	highWaterMark            atomic.Int64
+	timesBlocked             atomic.Int64
 }{
-	sync.NewCond(&sync.Mutex{}),
-	nil,
-	atomic.Int64{},
+	childDiedFreeingMemory: sync.NewCond(&sync.Mutex{}),
 }
 
-type chunkAllocator struct{ memory.Allocator }
+type chunkAllocator struct{ backend bufferBackend }
 
 func (allocator *chunkAllocator) mustCalloc(size int) []byte {
-	r, err := allocator.Calloc(size)
+	if allocator.backend == nil {
+		allocator.backend = newBufferBackend()
+	}
+
+	r, err := allocator.backend.Calloc(size)
 	if err != nil {
 		log.Fatalf("Could not allocate memory: %v\n", err)
 	}
@@ -32,7 +34,7 @@ func (allocator *chunkAllocator) mustCalloc(size int) []byte {
 }
 
 func (allocator *chunkAllocator) mustRealloc(mem []byte, size int) []byte {
-	r, err := allocator.Realloc(mem, size)
+	r, err := allocator.backend.Realloc(mem, size)
 	if err != nil {
 		log.Fatalf("Could not reallocate memory: %v\n", err)
 	}
@@ -40,13 +42,19 @@ func (allocator *chunkAllocator) mustRealloc(mem []byte, size int) []byte {
 }
 
 func (allocator *chunkAllocator) mustFree(mem []byte) {
-	if err := allocator.Free(mem); err != nil {
+	if allocator.backend == nil {
+		return
+	}
+	if err := allocator.backend.Free(mem); err != nil {
 		log.Fatalf("Could not free memory: %v\n", err)
 	}
 }
 
 func (allocator *chunkAllocator) mustClose() {
-	if err := allocator.Close(); err != nil {
+	if allocator.backend == nil {
+		return
+	}
+	if err := allocator.backend.Close(); err != nil {
 		log.Fatalf("Could not close allocator: %v\n", err)
 	}
 }
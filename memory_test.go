@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzChunks stores output split into arbitrary chunks, alternating between stdout and stderr at arbitrary points, and
+// checks that it's read back unchanged
+func FuzzChunks(f *testing.F) {
+	f.Add([]byte("hello\nworld\n"), []byte{3, 0, 9})
+	f.Add([]byte{}, []byte{})
+	f.Add([]byte("\x00\x01\x02"), []byte{1, 1, 1, 1})
+
+	f.Fuzz(func(t *testing.T, data []byte, splits []byte) {
+		out := &Output{}
+		// allocators left open pile up over a fuzzing run, until it runs out of memory
+		defer out.allocator.mustClose()
+
+		type chunk struct {
+			fd      byte
+			content []byte
+		}
+		var stored []chunk
+		for i, rest := 0, data; len(rest) > 0; i++ {
+			size := len(rest)
+			if i < len(splits) {
+				size = min(size, int(splits[i])+1)
+			}
+			fd := byte(1 + i%2)
+			out.appendChunk(fd, rest[:size])
+			stored = append(stored, chunk{fd, rest[:size]})
+			rest = rest[size:]
+		}
+
+		offset := 0
+		for i, expected := range stored {
+			fd, content, ok := out.getNextChunk(&offset)
+			if !ok {
+				t.Fatalf("chunk %d of %d is missing", i, len(stored))
+			}
+			if fd != expected.fd || !bytes.Equal(content, expected.content) {
+				t.Fatalf("chunk %d is %q on fd %d, instead of %q on fd %d", i, content, fd, expected.content, expected.fd)
+			}
+		}
+		if _, _, ok := out.getNextChunk(&offset); ok {
+			t.Fatalf("there are more chunks than the %d stored", len(stored))
+		}
+		out.allocator.mustFree(out.parts)
+	})
+}
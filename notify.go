@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// how long to wait for a --notify webhook to respond
+const notifyWebhookTimeout = 10 * time.Second
+
+// notification is the JSON payload POSTed to a --notify webhook
+type notification struct {
+	Command  []string `json:"command"`
+	Jobs     int      `json:"jobs"`
+	Failed   int      `json:"failed"`
+	Killed   int      `json:"killed"`
+	Duration float64  `json:"durationSeconds"`
+	ExitCode int      `json:"exitCode"`
+	Host     string   `json:"host"`
+}
+
+func validateNotifyFlag() {
+	for _, target := range *flNotify {
+		if target != "bell" && target != "desktop" && !isWebhook(target) {
+			errorWithUsage("--notify only accepts 'bell', 'desktop' and http(s) URLs, but got '%s'", target)
+		}
+	}
+}
+
+func isWebhook(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// notifyFinished lets the user know that the whole run has finished, in every way asked for with --notify
+func notifyFinished(command []string, exitCode int) {
+	failed, killed := countFailedJobs()
+	payload := notification{
+		Command:  command,
+		Jobs:     len(summary.jobs),
+		Failed:   failed,
+		Killed:   killed,
+		Duration: time.Since(summary.startedAt).Seconds(),
+		ExitCode: exitCode,
+	}
+	payload.Host, _ = os.Hostname()
+
+	for _, target := range *flNotify {
+		var err error
+		switch {
+		case target == "bell":
+			_, err = os.Stderr.WriteString("\a")
+		case target == "desktop":
+			err = notifyDesktop(payload)
+		default:
+			err = notifyWebhook(target, payload)
+		}
+		if err != nil {
+			log.Printf("Warning: could not --notify %s: %v\n", target, err)
+		}
+	}
+}
+
+func notifyDesktop(payload notification) error {
+	title := "gparallel has finished"
+	if payload.ExitCode != 0 {
+		title = "gparallel has failed"
+	}
+	message := fmt.Sprintf("%d jobs, %d failed, took %v", payload.Jobs, payload.Failed,
+		time.Duration(payload.Duration*float64(time.Second)).Round(time.Second))
+
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", message, title)
+		return exec.Command("osascript", "-e", script).Run()
+	default:
+		return exec.Command("notify-send", "--app-name=gparallel", title, message).Run()
+	}
+}
+
+func notifyWebhook(url string, payload notification) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: notifyWebhookTimeout}
+	response, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("got %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// spawningPaused holds off starting new commands, while the ones already running get to finish. Toggled with
+// --control-key followed by p, or with SIGUSR2
+var spawningPaused = struct {
+	mutex   sync.Mutex
+	paused  bool
+	resumed chan struct{}
+}{}
+
+func togglePause() {
+	spawningPaused.mutex.Lock()
+	defer spawningPaused.mutex.Unlock()
+
+	if spawningPaused.paused {
+		spawningPaused.paused = false
+		close(spawningPaused.resumed)
+		announce(fmt.Sprintf("%s: starting new commands again", os.Args[0]))
+		return
+	}
+
+	spawningPaused.paused = true
+	spawningPaused.resumed = make(chan struct{})
+	announce(fmt.Sprintf("%s: paused - not starting new commands until resumed", os.Args[0]))
+}
+
+func handlePause() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+
+	go func() {
+		for range signals {
+			togglePause()
+		}
+	}()
+}
+
+// waitWhilePaused blocks while spawning is paused. Returns false if we've stopped spawning in the meantime
+func waitWhilePaused() bool {
+	spawningPaused.mutex.Lock()
+	paused, resumed := spawningPaused.paused, spawningPaused.resumed
+	spawningPaused.mutex.Unlock()
+
+	if !paused {
+		return true
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-spawning.Done():
+		return false
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// journalEntry is a line of the --persist file: either a submitted job, or a note that the job with the id is done
+type journalEntry struct {
+	Id int
+
+	// a whole command that has been submitted, or the arguments for the command of the listening gparallel - which
+	// the job gets made out of again, with everything its flags give it
+	Command   []string `json:",omitempty"`
+	Arguments []string `json:",omitempty"`
+
+	Done bool `json:",omitempty"`
+}
+
+// the --persist file, which every submitted job gets appended to, so that it can be run after a restart if it
+// hasn't finished before
+var journal = struct {
+	mutex  sync.Mutex
+	file   *os.File
+	lastId int
+}{}
+
+// openJournal reads what's been submitted but hasn't finished yet, and starts a new journal with only that in it
+func openJournal(args Args) (pending []*Job) {
+	if err := os.MkdirAll(filepath.Dir(*flPersist), 0o700); err != nil {
+		log.Fatalf("Couldn't create directory '%s': %v\n", filepath.Dir(*flPersist), err)
+	}
+
+	var entries []journalEntry
+	if file, err := os.Open(*flPersist); err == nil {
+		entries = readJournal(file)
+		haveToClose("--persist file", file)
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("Could not open the --persist file: %v\n", err)
+	}
+
+	done := map[int]bool{}
+	for _, entry := range entries {
+		if entry.Done {
+			done[entry.Id] = true
+		}
+	}
+
+	var pendingEntries []journalEntry
+	for _, entry := range entries {
+		if !entry.Done && !done[entry.Id] {
+			pendingEntries = append(pendingEntries, entry)
+			journal.lastId = max(journal.lastId, entry.Id)
+		}
+	}
+
+	// write the compacted journal next to the old one and swap them, so that a crash in the middle loses nothing
+	compacted, err := os.CreateTemp(filepath.Dir(*flPersist), filepath.Base(*flPersist)+".*")
+	if err != nil {
+		log.Fatalf("Could not create a new --persist file: %v\n", err)
+	}
+	encoder := json.NewEncoder(compacted)
+	for _, entry := range pendingEntries {
+		if err := encoder.Encode(entry); err != nil {
+			log.Fatalf("Could not write to the --persist file: %v\n", err)
+		}
+		pending = append(pending, jobFromJournal(entry, args))
+	}
+	if err := os.Rename(compacted.Name(), *flPersist); err != nil {
+		log.Fatalf("Could not replace the --persist file: %v\n", err)
+	}
+
+	journal.file = compacted
+	return pending
+}
+
+// jobFromJournal makes a job out of what has been submitted again, just like acceptSubmission has
+func jobFromJournal(entry journalEntry, args Args) *Job {
+	job := &Job{command: entry.Command}
+	if entry.Arguments != nil {
+		job = newJob(args.command, entry.Arguments...)
+	}
+	job.journalId = entry.Id
+	return job
+}
+
+func readJournal(file io.Reader) (entries []journalEntry) {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// most probably the last line, written partially when we've been killed
+			log.Printf("Warning: skipping an unreadable line of the --persist file: %v\n", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Could not read the --persist file: %v\n", err)
+	}
+	return entries
+}
+
+func writeJournal(entry journalEntry) {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		log.Fatalf("Could not encode a --persist entry: %v\n", err)
+	}
+	if _, err := journal.file.Write(append(encoded, '\n')); err != nil {
+		log.Fatalf("Could not write to the --persist file: %v\n", err)
+	}
+}
+
+// persistJob records a job in the journal before it gets queued - made out of arguments for the command we've been
+// given, or a whole command submitted if it has no arguments
+func persistJob(job *Job, arguments []string) {
+	if *flPersist == "" {
+		return
+	}
+
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	journal.lastId += 1
+	job.journalId = journal.lastId
+	if arguments != nil {
+		writeJournal(journalEntry{Id: job.journalId, Arguments: arguments})
+	} else {
+		writeJournal(journalEntry{Id: job.journalId, Command: job.command})
+	}
+}
+
+// markPersistedJobDone records that a job doesn't need to be run again after a restart
+func markPersistedJobDone(proc *ProcessResult) {
+	if proc.journalId == 0 {
+		return
+	}
+
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	writeJournal(journalEntry{Id: proc.journalId, Done: true})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// pledge drops everything we won't need anymore once the setup is done. Commands get exec'd without any
+// restrictions of their own, and as they can be anything anywhere, as can --arg-file, --wd or --stdout files,
+// nothing gets unveiled.
+func pledge() {
+	// ptys need tty, the -P limit socket and --listen need unix, and gopsutil reads the state of our children with ps
+	promises := []string{"stdio", "rpath", "wpath", "cpath", "dpath", "fattr", "flock", "tty", "proc", "exec", "unix", "ps"}
+	for _, target := range *flNotify {
+		if isWebhook(target) {
+			promises = append(promises, "inet", "dns")
+			break
+		}
+	}
+	if childCredential != nil {
+		promises = append(promises, "id", "chown")
+	}
+
+	if err := unix.PledgePromises(strings.Join(promises, " ")); err != nil {
+		log.Printf("Warning: could not pledge: %v\n", err)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !openbsd
+
+package main
+
+// pledge only does anything on OpenBSD
+func pledge() {}
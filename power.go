@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// how long a check of whether we're on battery or too hot stays valid, and how often to check again while waiting
+const powerCheckInterval = 10 * time.Second
+
+// throttled tells whether only --battery-j commands should be running now: on battery, or with the CPU hotter than
+// --throttle-temp. Checked at most every powerCheckInterval
+var throttled = func() func() bool {
+	var mutex sync.Mutex
+	var checkedAt time.Time
+	var lastResult bool
+
+	return func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if time.Since(checkedAt) < powerCheckInterval {
+			return lastResult
+		}
+		checkedAt = time.Now()
+
+		wasThrottled := lastResult
+		reason := ""
+		if onBattery() {
+			reason = "running on battery"
+		} else if *flThrottleTemp > 0 {
+			if temperature, ok := cpuTemperature(); ok && temperature >= *flThrottleTemp {
+				reason = "the CPU being too hot"
+			}
+		}
+		lastResult = reason != ""
+
+		if lastResult && !wasThrottled {
+			verbosef(1, "Running at most %d commands at once, because of %s", *flBatteryJ, reason)
+		} else if !lastResult && wasThrottled {
+			verbosef(1, "Running up to %d commands at once again", *flMaxProcesses)
+		}
+		return lastResult
+	}
+}()
+
+func runningCount() int {
+	running.mutex.Lock()
+	defer running.mutex.Unlock()
+
+	return len(running.processes)
+}
+
+// waitForPowerBudget holds off starting another command while we're throttled and already running --battery-j of
+// them. Returns false if we've stopped spawning in the meantime
+func waitForPowerBudget() bool {
+	if *flBatteryJ == 0 {
+		return true
+	}
+
+	for throttled() && runningCount() >= *flBatteryJ {
+		select {
+		case <-time.After(time.Second):
+		case <-spawning.Done():
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func readSysfs(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// onBattery tells whether there's a mains power supply, none of which is online - machines without a battery
+// usually don't list any
+func onBattery() bool {
+	supplies, _ := filepath.Glob("/sys/class/power_supply/*")
+
+	anyMains := false
+	for _, supply := range supplies {
+		if readSysfs(filepath.Join(supply, "type")) != "Mains" {
+			continue
+		}
+		anyMains = true
+		if readSysfs(filepath.Join(supply, "online")) == "1" {
+			return false
+		}
+	}
+	return anyMains
+}
+
+// cpuTemperature is the highest temperature of any thermal zone, in degrees Celsius
+func cpuTemperature() (temperature int, ok bool) {
+	zones, _ := filepath.Glob("/sys/class/thermal/thermal_zone*/temp")
+	for _, zone := range zones {
+		milliCelsius, err := strconv.Atoi(readSysfs(zone))
+		if err != nil {
+			continue
+		}
+		temperature, ok = max(temperature, milliCelsius/1000), true
+	}
+	return temperature, ok
+}
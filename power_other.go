@@ -0,0 +1,27 @@
+//go:build !linux
+
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// onBattery asks pmset on macOS, and assumes we're not on battery anywhere else
+func onBattery() bool {
+	if runtime.GOOS != "darwin" {
+		return false
+	}
+
+	output, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(output), "'Battery Power'")
+}
+
+// cpuTemperature isn't known outside of Linux
+func cpuTemperature() (temperature int, ok bool) {
+	return 0, false
+}
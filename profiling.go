@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime/trace"
+)
+
+// startProfiling starts the --pprof http server and the --trace execution trace, if asked for
+func startProfiling() (stop func()) {
+	if *flPprof != "" {
+		listener, err := net.Listen("tcp", *flPprof)
+		if err != nil {
+			log.Fatalf("Could not listen for --pprof on %s: %v\n", *flPprof, err)
+		}
+		log.Printf("Serving profiles on http://%s/debug/pprof/\n", listener.Addr())
+
+		go func() {
+			// pprof registers itself on the default mux
+			err := http.Serve(listener, nil)
+			log.Printf("Warning: --pprof server stopped: %v\n", err)
+		}()
+	}
+
+	if *flTrace == "" {
+		return func() {}
+	}
+
+	traceFile, err := os.Create(*flTrace)
+	if err != nil {
+		log.Fatalf("Could not create the --trace file: %v\n", err)
+	}
+	if err := trace.Start(traceFile); err != nil {
+		log.Fatalf("Could not start tracing: %v\n", err)
+	}
+
+	return func() {
+		trace.Stop()
+		if err := traceFile.Close(); err != nil {
+			log.Printf("Warning: could not write the --trace file: %v\n", err)
+		}
+	}
+}
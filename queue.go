@@ -194,7 +194,7 @@ func startProcessesFromQueue(result chan<- *ProcessResult) {
 				continue
 			}
 
-			if noLongerSpawnChildren.Load() {
+			if spawning.Err() != nil {
 				break
 			}
 
@@ -203,9 +203,9 @@ func startProcessesFromQueue(result chan<- *ProcessResult) {
 					log.Fatalf("Queued WithStdin is true, but SlurpedStdin is nil: %+v\n", qc)
 				}
 
-				result <- runWithStdin(qc.Command, pipeWriter(qc.SlurpedStdin))
+				spawn(result, &Job{command: qc.Command, stdin: pipeWriter(qc.SlurpedStdin)})
 			} else {
-				result <- run(qc.Command)
+				spawn(result, &Job{command: qc.Command})
 			}
 		}
 
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"sync"
+)
+
+// raceWinner is the output of the first command to write anything or to finish, with --race
+var raceWinner = struct {
+	once   sync.Once
+	chosen chan struct{}
+	output *Output
+}{
+	chosen: make(chan struct{}),
+}
+
+// enterRace makes a command that's just written something or finished the winner of the --race, unless there
+// already is one. Everything else gets terminated then
+func enterRace(out *Output) {
+	if !*flRace {
+		return
+	}
+
+	raceWinner.once.Do(func() {
+		raceWinner.output = out
+		close(raceWinner.chosen)
+		stopSpawning()
+
+		running.mutex.Lock()
+		defer running.mutex.Unlock()
+		for proc := range running.processes {
+			if proc.output != out {
+				terminate(proc)
+			}
+		}
+	})
+}
+
+// lostRace waits for the --race to have a winner, and tells whether a command isn't it
+func lostRace(proc *ProcessResult) bool {
+	<-raceWinner.chosen
+	return proc.output != raceWinner.output
+}
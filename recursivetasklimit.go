@@ -15,6 +15,14 @@ import (
 	"golang.org/x/exp/slices"
 )
 
+// Nested gparallels share the -P limit of the outermost one, like jobs of a recursive make share a jobserver.
+//
+// The outermost gparallel listens on a unix socket, and passes its path down to everything it runs in
+// _GPARALLEL_CHILD_LIMIT_SOCKET. Every gparallel, the outermost one included, can always run one child without
+// asking - the one in the foreground, as it has to make progress no matter what. For every other child it connects
+// to the socket and waits for a byte to arrive: the connection is then a slot lent for running that child. When the
+// child exits, it writes a byte back and closes the connection to return the slot. The outermost gparallel accepts
+// -P - 1 connections at a time, so nothing ever runs more than -P children at once, however deep the nesting.
 const EnvGparallelChildLimitSocket = "_GPARALLEL_CHILD_LIMIT_SOCKET"
 
 func readOneByte(reader io.Reader) error {
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alessio/shellescape"
+)
+
+// Go can't run anything in a child between fork and exec, so --rlimit limits get set by a copy of ourselves started
+// with --_rlimit, which sets them on itself and execs the command - which then inherits them.
+
+// rlimit is a single resource limit of --rlimit
+type rlimit struct {
+	name     string
+	resource int
+	value    uint64
+}
+
+// how the value of a resource limit is given: in bytes with an optional suffix, as a count, or in seconds
+const (
+	rlimitBytes = iota
+	rlimitCount
+	rlimitSeconds
+)
+
+func parseRlimits(spec string) (limits []rlimit, err error) {
+	for _, setting := range strings.Split(spec, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(setting), "=")
+		if !found {
+			return nil, fmt.Errorf("'%s' is not in the form of name=value", setting)
+		}
+
+		resource, known := rlimitResources[name]
+		if !known {
+			return nil, fmt.Errorf("unknown resource '%s'%s", name, knownRlimitResources())
+		}
+
+		limit := rlimit{name: name, resource: resource.resource}
+		if value == "unlimited" {
+			limit.value = rlimInfinity
+		} else if limit.value, err = parseRlimitValue(resource.unit, value); err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %v", name, err)
+		}
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
+
+func parseRlimitValue(unit int, value string) (uint64, error) {
+	switch unit {
+	case rlimitBytes:
+		size, err := parseSize(value)
+		return uint64(size), err
+	case rlimitSeconds:
+		if duration, err := time.ParseDuration(value); err == nil {
+			return uint64(duration.Round(time.Second) / time.Second), nil
+		}
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+func knownRlimitResources() string {
+	if len(rlimitResources) == 0 {
+		return ", as --rlimit isn't supported on this system"
+	}
+
+	var names []string
+	for name := range rlimitResources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return ", expected one of " + strings.Join(names, ", ")
+}
+
+// withRlimits makes a command get started with the --rlimit limits set
+func withRlimits(command []string) []string {
+	if *flRlimit == "" {
+		return command
+	}
+	return append([]string{executable(), "--_rlimit", *flRlimit, "--"}, command...)
+}
+
+// executeWithRlimits sets the resource limits on ourselves and becomes the command, for --_rlimit
+func executeWithRlimits(spec string, command []string) (exitCode int) {
+	limits, err := parseRlimits(spec)
+	if err != nil {
+		log.Fatalf("Invalid --rlimit: %v\n", err)
+	}
+
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		log.Printf("Could not find executable %s: %v\n", command[0], err)
+		return 127
+	}
+
+	for _, limit := range limits {
+		if err := setRlimit(limit.resource, limit.value); err != nil {
+			log.Printf("Could not limit %s of %s: %v\n", limit.name, shellescape.QuoteCommand(command), err)
+			return 126
+		}
+	}
+
+	err = syscall.Exec(path, command, os.Environ())
+	log.Printf("Could not execute %s: %v\n", shellescape.QuoteCommand(command), err)
+	return 126
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+const rlimInfinity = ^uint64(0)
+
+var rlimitResources = map[string]struct {
+	resource int
+	unit     int
+}{}
+
+func setRlimit(resource int, value uint64) error {
+	return errors.New("not supported on this system")
+}
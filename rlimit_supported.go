@@ -0,0 +1,25 @@
+//go:build linux || darwin
+
+package main
+
+import "golang.org/x/sys/unix"
+
+const rlimInfinity = unix.RLIM_INFINITY
+
+var rlimitResources = map[string]struct {
+	resource int
+	unit     int
+}{
+	"as":     {unix.RLIMIT_AS, rlimitBytes},
+	"core":   {unix.RLIMIT_CORE, rlimitBytes},
+	"cpu":    {unix.RLIMIT_CPU, rlimitSeconds},
+	"data":   {unix.RLIMIT_DATA, rlimitBytes},
+	"fsize":  {unix.RLIMIT_FSIZE, rlimitBytes},
+	"nofile": {unix.RLIMIT_NOFILE, rlimitCount},
+	"stack":  {unix.RLIMIT_STACK, rlimitBytes},
+}
+
+// setRlimit sets both the soft and the hard limit, so that the command can't raise it back
+func setRlimit(resource int, value uint64) error {
+	return unix.Setrlimit(resource, &unix.Rlimit{Cur: value, Max: value})
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"log"
@@ -10,7 +14,9 @@ import (
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -29,20 +35,45 @@ type Output struct {
 	shouldPassToParent bool
 	stdoutPipeOrPty    *os.File
 	stderrPipeOrPty    *os.File
+	stdinPty           *os.File
 	winchSignal        chan os.Signal
 	streamClosed       chan struct{}
 	allocator          chunkAllocator
+	termModes          terminalModes
+	lastActivity       atomic.Int64 // unix nanoseconds
+	waitingForMemory   atomic.Bool
+	collectedStdout    *bytes.Buffer // stdout kept for --diff-outputs, instead of being written out
+	stdoutChecksum     hash.Hash     // of everything the child has written to stdout, with --checksum
+	redirects          [3]*os.File   // files from --stdout and --stderr, written to instead of storing output
+	cache              *cacheEntry   // where the output is kept as well, with --cache
+	alerts             *alertForwarder
+	inspectedBy        []string // whatever has to see everything the child writes, see inspect
 }
 
 type ProcessResult struct {
 	startedAt       time.Time
+	finishedAt      time.Time
+	killedBy        syscall.Signal
 	output          *Output
 	originalCommand []string
 	cmd             *exec.Cmd
-	exitCode        chan int
+	exitCode        chan int // gets the exit code once, when the job finishes
+	finished        chan struct{}
+	finalExitCode   int // only set once finished gets closed, see finish
+	slot            int
+	seq             int
+	jobserverToken  jobserverToken
+	journalId       int
+	groupKey        string
+	variants        *variantGroup
 }
 
 func (proc *ProcessResult) isAlive() bool {
+	if proc.cmd == nil {
+		// never started
+		return false
+	}
+
 	p, err := process.NewProcess(int32(proc.cmd.Process.Pid))
 	if err != nil {
 		return false
@@ -56,34 +87,121 @@ func (proc *ProcessResult) isAlive() bool {
 	return !slices.Contains(statuses, process.Zombie)
 }
 
-func (proc *ProcessResult) wait() error {
-	defer recursiveTaskLimitClient().del(proc)
+func (proc *ProcessResult) wait(ctx context.Context) error {
+	err := proc.cmd.Wait()
+
+	if !proc.waitForStreams(ctx) {
+		proc.output.appendNotice(fmt.Sprintf(
+			"%s has exited, but processes it left behind still held its output open after --linger %v - not waiting for them",
+			shellescape.QuoteCommand(proc.originalCommand),
+			*flLinger))
+	}
+
+	signal.Stop(proc.output.winchSignal)
 
+	return err
+}
+
+// waitForStreams waits for the child's stdout and stderr to get closed, giving up after --linger if something the
+// child has spawned keeps them open after the child itself has exited - or right away once ctx is cancelled
+func (proc *ProcessResult) waitForStreams(ctx context.Context) (closedByChild bool) {
 	// wait for both stdout and stderr if we opened two readers
-	<-proc.output.streamClosed
+	openStreams := 1
 	if !stdoutAndStderrAreTheSame() {
-		<-proc.output.streamClosed
+		openStreams = 2
 	}
 
-	signal.Stop(proc.output.winchSignal)
+	var lingerExpired <-chan time.Time
+	if *flLinger > 0 {
+		lingerExpired = time.After(*flLinger)
+	}
+
+	// make the readers stop at whatever they've got at this moment
+	stopReaders := func() {
+		now := time.Now()
+		_ = proc.output.stdoutPipeOrPty.SetReadDeadline(now)
+		_ = proc.output.stderrPipeOrPty.SetReadDeadline(now)
+	}
+
+	done := ctx.Done()
+	closedByChild = true
+	for openStreams > 0 {
+		select {
+		case <-proc.output.streamClosed:
+			openStreams -= 1
+		case <-lingerExpired:
+			closedByChild = false
+			lingerExpired = nil
+			stopReaders()
+		case <-done:
+			// nothing more is going to be shown, so there's no one to tell about what's been left behind
+			done = nil
+			lingerExpired = nil
+			stopReaders()
+		}
+	}
+
+	return closedByChild
+}
+
+// appendNotice adds a message from us to the child's stderr, so it's shown next to the rest of its output
+func (out *Output) appendNotice(message string) {
+	notice := []byte(fmt.Sprintf("%s: %s\n", os.Args[0], message))
 
-	return proc.cmd.Wait()
+	waitIfUsingTooMuchMemory(chunkSizeWithHeader(notice), out)
+	out.appendOrWrite(notice, syscall.Stderr)
+}
+
+// appendNoticeWithoutWaiting is appendNotice for goroutines other than the child's readers, like the stall watchdog -
+// which mustn't wait for memory, nor make it look like the readers aren't waiting for it. Goes over --max-mem if it
+// has to, by just the size of the notice
+func (out *Output) appendNoticeWithoutWaiting(message string) {
+	notice := []byte(fmt.Sprintf("%s: %s\n", os.Args[0], message))
+
+	mem.childDiedFreeingMemory.L.Lock()
+	if mem.currentlyInTheForeground != out {
+		countStored(chunkSizeWithHeader(notice))
+	}
+	mem.childDiedFreeingMemory.L.Unlock()
+
+	out.appendOrWrite(notice, syscall.Stderr)
 }
 
 func (out *Output) appendOrWrite(buf []byte, dataFromFd int) {
 	out.partsMutex.Lock()
 	defer out.partsMutex.Unlock()
 
-	if out.shouldPassToParent {
+	if out.shouldPassToParent && out.collectsStdout(dataFromFd) {
+		out.collect(buf)
+	} else if out.shouldPassToParent {
+		out.termModes.observe(dataFromFd, buf)
 		_, err := standardFdToFile[dataFromFd].Write(buf)
 		if err != nil {
 			log.Fatalf("Syscall write to fd %d: %v\n", dataFromFd, err)
 		}
+		flushSidebandAfter(out)
 	} else {
+		if out.alerts != nil {
+			// whatever was in it might've been forwarded already
+			if buf = out.alerts.filter(dataFromFd, buf); len(buf) == 0 {
+				return
+			}
+		}
 		out.appendChunk(byte(dataFromFd), buf)
 	}
 }
 
+// countStored adds to how much output is stored, returning the new total. Has to be called with
+// mem.childDiedFreeingMemory.L held
+func countStored(willSaveBytes int64) int64 {
+	stored := mem.currentlyStored.Add(willSaveBytes)
+	if stored > mem.highWaterMark.Load() {
+		// only ever modified under the lock, so no need for compare-and-swap
+		mem.highWaterMark.Store(stored)
+	}
+	return stored
+}
+
 func waitIfUsingTooMuchMemory(willSaveBytes int64, out *Output) {
 	mem.childDiedFreeingMemory.L.Lock()
 	defer mem.childDiedFreeingMemory.L.Unlock()
@@ -92,46 +210,161 @@ func waitIfUsingTooMuchMemory(willSaveBytes int64, out *Output) {
 		return
 	}
 
-	mem.currentlyStored.Add(willSaveBytes)
-	for mem.currentlyStored.Load() > parsedFlMaxMemory {
-		//log.Printf("Blocking because we're storing %d MiB (here: %d)\n",
-		//	mem.currentlyStored.Load()/1024/1024,
-		//	len(out.parts)/1024/1024)
+	stored := countStored(willSaveBytes)
+
+	out.waitingForMemory.Store(true)
+	defer out.waitingForMemory.Store(false)
+
+	if stored <= parsedFlMaxMemory {
+		return
+	}
+
+	mem.timesBlocked.Add(1)
+	blockedSince := time.Now()
+	debugf("memory", "%s stored with --max-mem %s, pausing a reader", mebibytes(stored), mebibytes(parsedFlMaxMemory))
+	verbosef(3, "Pausing a command in the background, as %s of output is already stored", mebibytes(stored))
+
+	// output shown by now doesn't have to wait anymore, even if what's still stored is more than --max-mem - which
+	// can be because of the stdouts kept for --diff-outputs
+	for mem.currentlyStored.Load() > parsedFlMaxMemory && mem.currentlyInTheForeground != out {
 		mem.childDiedFreeingMemory.Wait()
 	}
+
+	verbosef(3, "Resuming a command in the background after %v", time.Since(blockedSince).Round(time.Millisecond))
 }
 
-func readContinuouslyTo(stream io.ReadCloser, out *Output, fileDescriptor int) {
+// readBuffers are reused between the readers of children - with lots of short-lived children, allocating a new one
+// for each of them is most of the garbage we make
+var readBuffers = sync.Pool{New: func() any {
 	buffer := make([]byte, MAXBUF)
+	return &buffer
+}}
+
+// inspect registers something that has to see everything the child writes, before any reader starts - its output
+// then never gets spliced past us
+func (out *Output) inspect(by string) {
+	out.inspectedBy = append(out.inspectedBy, by)
+}
+
+// canSplice tells whether the output of the child can go straight to ours once it's in the foreground, without us
+// seeing any of it
+func (out *Output) canSplice() bool {
+	// output going to a terminal is being looked at for terminal modes
+	if stdoutIsTty() {
+		return false
+	}
+	if len(out.inspectedBy) > 0 {
+		debugf("pty", "not splicing output, as it's needed by %s", strings.Join(out.inspectedBy, ", "))
+		return false
+	}
+	return true
+}
+
+// readContinuouslyTo reads a stream of a child until it's closed, storing or showing what's been read - or just
+// dropping it once ctx is cancelled
+func readContinuouslyTo(ctx context.Context, stream *os.File, out *Output, fileDescriptor int) {
+	pooledBuffer := readBuffers.Get().(*[]byte)
+	defer readBuffers.Put(pooledBuffer)
+	buffer := *pooledBuffer
+
+	if file := out.redirects[fileDescriptor]; file != nil {
+		redirectContinuouslyTo(stream, out, fileDescriptor, file, buffer)
+		out.streamClosed <- struct{}{}
+		return
+	}
+
+	canSplice := out.canSplice()
 
 	for {
+		if canSplice && out.isPassedToParent() {
+			if spliced, err := spliceToParent(stream, out, fileDescriptor); spliced {
+				stopReading(stream, err)
+				break
+			}
+			canSplice = false
+		}
+
 		count, err := stream.Read(buffer)
 
-		if count > 0 {
+		if count > 0 && ctx.Err() == nil {
+			if out.stdoutChecksum != nil && fileDescriptor == syscall.Stdout {
+				out.stdoutChecksum.Write(buffer[:count])
+			}
+			out.lastActivity.Store(time.Now().UnixNano())
+			enterRace(out)
+			if out.cache != nil {
+				out.cache.record(fileDescriptor, buffer[:count])
+			}
 			waitIfUsingTooMuchMemory(chunkSizeWithHeader(buffer[:count]), out)
 			out.appendOrWrite(buffer[:count], fileDescriptor)
 		}
 
 		if err != nil {
-			if err == io.EOF {
-				haveToClose("child stdout/stderr after EOF", stream)
-				break
-			}
-			if errors.Is(err, fs.ErrClosed) {
-				break
+			stopReading(stream, err)
+			break
+		}
+	}
+
+	out.streamClosed <- struct{}{}
+}
+
+// redirectContinuouslyTo writes everything from a stream of a child straight into its --stdout or --stderr file
+func redirectContinuouslyTo(stream *os.File, out *Output, fileDescriptor int, file *os.File, buffer []byte) {
+	defer haveToClose("--stdout/--stderr file", file)
+
+	var writeErr error
+	for {
+		count, err := stream.Read(buffer)
+
+		if count > 0 {
+			out.lastActivity.Store(time.Now().UnixNano())
+			if out.stdoutChecksum != nil && fileDescriptor == syscall.Stdout {
+				out.stdoutChecksum.Write(buffer[:count])
 			}
-			var pathError *os.PathError
-			if errors.As(err, &pathError) && pathError.Err == syscall.EIO {
-				// Returning EIO is Linux's way of saying the other end is closed when reading from a ptmx:
-				// https://github.com/creack/pty/issues/21
-				haveToClose("child stdout/stderr after EIO", stream)
-				break
+			if writeErr == nil {
+				// keep reading even if writing fails, so that the child doesn't get stuck
+				if _, writeErr = file.Write(buffer[:count]); writeErr != nil {
+					out.appendNotice(fmt.Sprintf("could not write the output to %s: %v", file.Name(), writeErr))
+				}
 			}
-			log.Fatalf("error from read: %v\n", err)
+		}
+
+		if err != nil {
+			stopReading(stream, err)
+			return
 		}
 	}
+}
 
-	out.streamClosed <- struct{}{}
+// stopReading deals with the error that has ended reading the stdout or stderr of a child
+func stopReading(stream io.Closer, err error) {
+	if err == io.EOF {
+		haveToClose("child stdout/stderr after EOF", stream)
+		return
+	}
+	if errors.Is(err, fs.ErrClosed) {
+		return
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		// we've stopped waiting for this stream - see waitForStreams
+		haveToClose("child stdout/stderr after --linger", stream)
+		return
+	}
+	var pathError *os.PathError
+	if errors.As(err, &pathError) && pathError.Err == syscall.EIO {
+		// Returning EIO is Linux's way of saying the other end is closed when reading from a ptmx:
+		// https://github.com/creack/pty/issues/21
+		haveToClose("child stdout/stderr after EIO", stream)
+		return
+	}
+	log.Fatalf("error from read: %v\n", err)
+}
+
+func (out *Output) isPassedToParent() bool {
+	out.partsMutex.Lock()
+	defer out.partsMutex.Unlock()
+
+	return out.shouldPassToParent
 }
 
 func haveToClose(name string, closer io.Closer) {
@@ -148,6 +381,7 @@ func createPty(winSize *ptyPkg.Winsize) (pty, tty *os.File, err error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	debugf("pty", "opened %s, %dx%d", tty.Name(), winSize.Cols, winSize.Rows)
 	defer func() {
 		if err != nil {
 			_ = pty.Close()
@@ -187,8 +421,32 @@ func createPty(winSize *ptyPkg.Winsize) (pty, tty *os.File, err error) {
 	return os.NewFile(uintptr(asyncPtyFd), "nonblocking /dev/ptmx"), tty, err
 }
 
+// childWindowSize is the size of the ptys of commands: of our terminal, unless overridden with --columns and --rows
+func childWindowSize() (*ptyPkg.Winsize, error) {
+	size, err := ptyPkg.GetsizeFull(os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	if *flColumns > 0 {
+		size.Cols = uint16(*flColumns)
+	}
+	if *flRows > 0 {
+		size.Rows = uint16(*flRows)
+	}
+	return size, nil
+}
+
+var ptysMissing sync.Once
+
+// warnAboutMissingPtys tells once that commands get pipes instead of ptys, as the system has run out of them - which
+// happens easily with a high -P, as some systems (like the BSDs and macOS) have only a couple hundred of them
+func warnAboutMissingPtys(err error) {
+	ptysMissing.Do(func() {
+		log.Printf("Warning: could not create a pty (%v), giving commands pipes instead until more ptys are free\n", err)
+	})
+}
+
 func runInteractive(cmd *exec.Cmd) *Output {
-	cmd.Env = os.Environ()
 	if originalGoMaxProcs, exists := os.LookupEnv("GOMAXPROCS"); exists {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("_GPARALLEL_ORIGINAL_GOMAXPROCS=%s", originalGoMaxProcs))
 	}
@@ -197,14 +455,15 @@ func runInteractive(cmd *exec.Cmd) *Output {
 	out := &Output{}
 	var stdoutTty, stderrTty *os.File
 
-	size, err := ptyPkg.GetsizeFull(os.Stdout)
+	size, err := childWindowSize()
 	if err != nil {
 		log.Fatalf("Could not get terminal size: %v\n", err)
 	}
 
 	out.stdoutPipeOrPty, stdoutTty, err = createPty(size)
 	if err != nil {
-		log.Fatalf("Couldn't create a pty for %v's stdout: %v\n", cmd.Args, err)
+		warnAboutMissingPtys(err)
+		return runNonInteractive(cmd)
 	}
 	defer haveToClose("stdout tty", stdoutTty)
 
@@ -213,7 +472,9 @@ func runInteractive(cmd *exec.Cmd) *Output {
 	} else {
 		out.stderrPipeOrPty, stderrTty, err = createPty(size)
 		if err != nil {
-			log.Fatalf("Couldn't create a pty for %v's stderr: %v\n", cmd.Args, err)
+			haveToClose("stdout pty", out.stdoutPipeOrPty)
+			warnAboutMissingPtys(err)
+			return runNonInteractive(cmd)
 		}
 		defer haveToClose("stderr tty", stderrTty)
 	}
@@ -223,6 +484,8 @@ func runInteractive(cmd *exec.Cmd) *Output {
 		Setctty: true,
 		Ctty:    1,
 	}
+	runAsChild(cmd)
+	isolate(cmd)
 
 	out.winchSignal = make(chan os.Signal, 1)
 	signal.Notify(out.winchSignal, syscall.SIGWINCH)
@@ -230,7 +493,7 @@ func runInteractive(cmd *exec.Cmd) *Output {
 		for range out.winchSignal {
 			// TODO: this should handle just one of stderr/stdout being closed
 
-			size, err := ptyPkg.GetsizeFull(os.Stdout)
+			size, err := childWindowSize()
 			if err != nil {
 				log.Fatalf("Could not get terminal size on sigwinch: %v\n", err)
 			}
@@ -245,6 +508,7 @@ func runInteractive(cmd *exec.Cmd) *Output {
 
 	if cmd.Stdin == nil {
 		cmd.Stdin = stdoutTty
+		out.stdinPty = out.stdoutPipeOrPty
 	}
 	cmd.Stdout = stdoutTty
 	cmd.Stderr = stderrTty
@@ -279,6 +543,13 @@ func runNonInteractive(cmd *exec.Cmd) *Output {
 		defer haveToClose("stderr pipe", stderrWritePipe)
 	}
 
+	// put the child in its own process group, so that it and everything it spawns can be signalled at once
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+	runAsChild(cmd)
+	isolate(cmd)
+
 	cmd.Stdout = stdoutWritePipe
 	cmd.Stderr = stderrWritePipe
 	err = cmd.Start()
@@ -306,52 +577,188 @@ func executable() string {
 	}
 }
 
-func runWithStdin(command []string, stdin io.Reader) (result *ProcessResult) {
+// runJob starts a job, returning nil if we have stopped starting new ones while waiting for our turn. Once ctx is
+// cancelled, what the job writes is no longer kept, and it isn't waited for past its own exit
+func runJob(ctx context.Context, job *Job) (result *ProcessResult) {
+	if *flShardBy != "" {
+		if !waitForSameShardKey(job) {
+			job.abandon()
+			return nil
+		}
+		defer func() {
+			if result != nil {
+				rememberShardKey(job, result)
+			}
+		}()
+	}
+
+	if job.markerExists() {
+		job.abandon()
+		return nil
+	}
+
+	job.seq = int(startedJobs.Add(1))
+
+	var key string
+	if *flCache != "" {
+		key = cacheKey(job)
+		if transcript, ok := lookupCache(key); ok {
+			return replayFromCache(job, transcript)
+		}
+	}
+
+	if err := job.prepare(); err != nil {
+		return failedToStart(job, err)
+	}
+
 	result = &ProcessResult{}
-	result.originalCommand = command
-	result.exitCode = make(chan int)
+	result.originalCommand = job.command
+	result.exitCode = make(chan int, 1)
+	result.finished = make(chan struct{})
+
+	result.seq = job.seq
+	result.journalId = job.journalId
+	result.groupKey = job.groupKey
+	result.variants = job.variants
 
+	waitingSince := time.Now()
 	recursiveTaskLimitClient().addWait(result)
+	debugf("scheduler", "#%d got through the -P limit after %v", result.seq, time.Since(waitingSince))
+	// spawning could have been paused while this one waited for its turn
+	if !waitWhilePaused() || spawning.Err() != nil {
+		recursiveTaskLimitClient().del(result)
+		job.abandon()
+		return nil
+	}
+	if ok, err := job.takeLock(); !ok {
+		recursiveTaskLimitClient().del(result)
+		if err != nil {
+			return failedToStart(job, fmt.Errorf("could not take the --flock: %w", err))
+		}
+		job.abandon()
+		return nil
+	}
+	if server := jobserver(); server != nil {
+		token, ok := server.acquire()
+		if !ok {
+			recursiveTaskLimitClient().del(result)
+			job.abandon()
+			return nil
+		}
+		result.jobserverToken = token
+	}
+	result.slot = acquireSlot()
 
-	if stdoutIsTty() {
-		command = append([]string{executable(), "--_execute-and-flush-tty"}, command...)
+	// only checked now, as waiting for our turn could have taken long enough for the free space to have changed
+	if !waitForDiskSpace() {
+		releaseSlot(result.slot)
+		if server := jobserver(); server != nil {
+			server.release(result.jobserverToken)
+		}
+		recursiveTaskLimitClient().del(result)
+		job.abandon()
+		return nil
 	}
 
-	result.cmd = exec.Command(command[0], command[1:]...)
-	result.cmd.Stdin = stdin
+	hookOutput, failed := runBeforeHook(job, result.slot)
+	if failed != nil {
+		releaseSlot(result.slot)
+		if server := jobserver(); server != nil {
+			server.release(result.jobserverToken)
+		}
+		recursiveTaskLimitClient().del(result)
+		job.closeRedirects()
+		job.removeScratch(1, failed.output)
+		return failed
+	}
 
-	if stdoutIsTty() {
-		result.output = runInteractive(result.cmd)
-	} else {
-		result.output = runNonInteractive(result.cmd)
+	command := job.executedCommand()
+	result.cmd = exec.Command(command[0], command[1:]...)
+	result.cmd.Stdin = job.stdin
+	result.cmd.Dir = job.workDir
+	result.cmd.Env = job.environ(result.slot)
+
+	result.output = chosenBackend().start(result.cmd)
+	if job.variants != nil {
+		result.output.collectedStdout = &bytes.Buffer{}
+		result.output.inspect("--diff-outputs")
 	}
+	if *flChecksum {
+		result.output.stdoutChecksum = sha256.New()
+		result.output.inspect("--checksum")
+	}
+	result.output.redirects = job.redirects
+	if key != "" {
+		result.output.cache = newCacheEntry(key)
+		result.output.inspect("--cache")
+	}
+	if *flForwardAlerts && stdoutIsTty() {
+		result.output.alerts = newAlertForwarder(job)
+		result.output.inspect("--forward-alerts")
+	}
+	if *flRace {
+		result.output.inspect("--race")
+	}
+	job.closeStdin()
+	addRunning(result)
+	hookOutput.appendTo(result.output)
 
 	result.output.streamClosed = make(chan struct{}, 2)
-	go readContinuouslyTo(result.output.stdoutPipeOrPty, result.output, syscall.Stdout)
+	go readContinuouslyTo(ctx, result.output.stdoutPipeOrPty, result.output, syscall.Stdout)
 	if !stdoutAndStderrAreTheSame() {
-		go readContinuouslyTo(result.output.stderrPipeOrPty, result.output, syscall.Stderr)
+		go readContinuouslyTo(ctx, result.output.stderrPipeOrPty, result.output, syscall.Stderr)
 	}
 
 	result.startedAt = time.Now()
+	result.output.lastActivity.Store(result.startedAt.UnixNano())
+
+	quotedCommand := abbreviate(shellescape.QuoteCommand(job.command), 200)
+	if *flVerbose >= 3 {
+		verbosef(3, "Started #%d in slot %d, after waiting %v for a free slot: %s",
+			result.seq, result.slot, result.startedAt.Sub(waitingSince).Round(time.Millisecond), quotedCommand)
+	} else {
+		verbosef(2, "Started #%d: %s", result.seq, quotedCommand)
+	}
 
 	go func() {
-		err := result.wait()
+		err := result.wait(ctx)
 
 		// Check if our child exited unsuccessfully
+		exitCode := 0
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
-			result.exitCode <- exitErr.ExitCode()
-			return
-		}
-		if err != nil {
+			exitCode = exitErr.ExitCode()
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				// report deaths by signals the same way shells do
+				result.killedBy = status.Signal()
+				exitCode = 128 + int(status.Signal())
+			}
+		} else if err != nil {
 			log.Fatalf("Failed to wait for command %s: %v\n", shellescape.QuoteCommand(command), err)
 		}
-		result.exitCode <- 0
+
+		exitCode = runAfterHook(result, job, exitCode)
+		job.removeScratch(exitCode, result.output)
+		result.output.cache.finish(exitCode)
+		job.createMarker(exitCode, result.output)
+		job.releaseLock()
+
+		result.finishedAt = time.Now()
+		recursiveTaskLimitClient().del(result)
+		removeRunning(result)
+		releaseSlot(result.slot)
+		if server := jobserver(); server != nil {
+			server.release(result.jobserverToken)
+		}
+
+		verbosef(2, "Finished #%d with exit code %d after %v: %s",
+			result.seq, exitCode, result.finishedAt.Sub(result.startedAt).Round(time.Millisecond), quotedCommand)
+		result.finish(exitCode)
 	}()
 
-	return result
-}
+	if *flStallTimeout > 0 {
+		go result.watchForStalls()
+	}
 
-func run(command []string) (result *ProcessResult) {
-	return runWithStdin(command, nil)
+	return result
 }
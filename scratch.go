@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// scratchPlaceholder gets replaced with the --scratch directory of a job
+const scratchPlaceholder = "{tmp}"
+
+// createScratch gives the job a new directory of its own for --scratch, replacing {tmp} with it everywhere
+func (job *Job) createScratch() error {
+	dir, err := os.MkdirTemp("", "gparallel-scratch-*")
+	if err != nil {
+		return fmt.Errorf("could not create a --scratch directory: %w", err)
+	}
+	job.scratchDir = dir
+	if err := giveToChild(dir); err != nil {
+		return fmt.Errorf("could not give the --scratch directory to --user: %w", err)
+	}
+
+	replace := func(s string) string {
+		return strings.ReplaceAll(s, scratchPlaceholder, dir)
+	}
+	for i := range job.command {
+		job.command[i] = replace(job.command[i])
+	}
+	for i := range job.env {
+		job.env[i] = replace(job.env[i])
+	}
+	job.workDir = replace(job.workDir)
+	job.stdinFile = replace(job.stdinFile)
+	job.stdoutFile = replace(job.stdoutFile)
+	job.stderrFile = replace(job.stderrFile)
+	job.before = replace(job.before)
+	job.after = replace(job.after)
+
+	return nil
+}
+
+// removeScratch deletes the --scratch directory of a job that has ended - unless it has failed and
+// --keep-failed-scratch asks for keeping it around
+func (job *Job) removeScratch(exitCode int, out *Output) {
+	if job.scratchDir == "" {
+		return
+	}
+
+	if exitCode != 0 && *flKeepFailedScratch {
+		out.appendNotice(fmt.Sprintf("kept the scratch directory of the failed command: %s", job.scratchDir))
+		return
+	}
+
+	if err := os.RemoveAll(job.scratchDir); err != nil {
+		out.appendNotice(fmt.Sprintf("Warning: could not remove scratch directory %s: %v", job.scratchDir, err))
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// --shard K/N, with K counted from 1. Every gparallel sharing the same input takes different jobs out of it, by
+// their position in the input - so the input has to be the same, in the same order, for all of them
+var parsedFlShard struct {
+	index int
+	count int
+}
+
+func shardFromFlag() {
+	if *flShard == "" {
+		return
+	}
+
+	index, count, found := strings.Cut(*flShard, "/")
+	var err1, err2 error
+	parsedFlShard.index, err1 = strconv.Atoi(index)
+	parsedFlShard.count, err2 = strconv.Atoi(count)
+	if !found || err1 != nil || err2 != nil {
+		errorWithUsage("Invalid value of the --shard flag: expected K/N, like 2/4, but got '%s'", *flShard)
+	}
+	if parsedFlShard.count < 1 || parsedFlShard.index < 1 || parsedFlShard.index > parsedFlShard.count {
+		errorWithUsage("Invalid value of the --shard flag: %s is not one of the shards from 1/%d to %d/%d",
+			*flShard, parsedFlShard.count, parsedFlShard.count, parsedFlShard.count)
+	}
+}
+
+// shardedSource only takes every N-th job of another source, starting from the K-th one, for --shard K/N
+type shardedSource struct {
+	inputSource
+	position int
+}
+
+func (source *shardedSource) next() (*Job, error) {
+	for {
+		job, err := source.inputSource.next()
+		if err != nil {
+			return nil, err
+		}
+
+		position := source.position
+		source.position += 1
+		if position%parsedFlShard.count != parsedFlShard.index-1 {
+			job.abandon()
+			continue
+		}
+
+		if job.total > 0 {
+			job.total = (job.total - parsedFlShard.index + parsedFlShard.count) / parsedFlShard.count
+		}
+		return job, nil
+	}
+}
+
+// the last job started for every --shard-by key, finishing before the next one with the same key can start
+var shardKeys = struct {
+	mutex    sync.Mutex
+	finished map[string]<-chan struct{}
+}{finished: map[string]<-chan struct{}{}}
+
+// waitForSameShardKey waits for the previous job with the same --shard-by key to finish, so that jobs sharing a key
+// run one after another, in the order of the input. Returns false if we've stopped spawning in the meantime
+func waitForSameShardKey(job *Job) bool {
+	shardKeys.mutex.Lock()
+	previous := shardKeys.finished[job.shardKey]
+	shardKeys.mutex.Unlock()
+	if previous == nil {
+		return true
+	}
+
+	select {
+	case <-previous:
+		return true
+	default:
+	}
+
+	verbosef(3, "Waiting for the previous command with the --shard-by key '%s' to finish", job.shardKey)
+	select {
+	case <-previous:
+		return true
+	case <-spawning.Done():
+		return false
+	}
+}
+
+func rememberShardKey(job *Job, result *ProcessResult) {
+	shardKeys.mutex.Lock()
+	defer shardKeys.mutex.Unlock()
+	shardKeys.finished[job.shardKey] = result.finished
+}
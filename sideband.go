@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// sideband is what we write to the terminal ourselves while the output of commands is being shown - forwarded
+// alerts and the --set-title. It's held back while the output shown last has stopped in the middle of an escape
+// sequence (or of a character), which writing anything else would break up
+var sideband = struct {
+	mutex   sync.Mutex
+	pending []byte
+	queued  atomic.Bool
+	shown   atomic.Pointer[Output] // the output written out to the terminal last
+	kick    chan struct{}
+	flusher sync.Once
+}{
+	kick: make(chan struct{}, 1),
+}
+
+// writeSideband queues something to be written to the terminal as soon as it can be. Doesn't block, so it can be
+// called with the partsMutex of any output held
+func writeSideband(data string) {
+	sideband.mutex.Lock()
+	sideband.pending = append(sideband.pending, data...)
+	sideband.queued.Store(true)
+	sideband.mutex.Unlock()
+
+	sideband.flusher.Do(func() { go flushSidebandWhenPossible() })
+	select {
+	case sideband.kick <- struct{}{}:
+	default:
+	}
+}
+
+// flushSidebandWhenPossible writes out what's been queued for the terminal right away if the output shown last is
+// at a boundary - otherwise it's written after the next write of that output which ends at one, see
+// flushSidebandAfter
+func flushSidebandWhenPossible() {
+	for range sideband.kick {
+		out := sideband.shown.Load()
+		if out == nil {
+			flushSideband()
+			continue
+		}
+
+		out.partsMutex.Lock()
+		if out == sideband.shown.Load() && out.termModes.atBoundary() {
+			flushSideband()
+		}
+		out.partsMutex.Unlock()
+	}
+}
+
+// showingOutput makes an output the one whose escape sequences sideband writes have to wait for, flushing what's been
+// waiting for the previous one. Has to be called with its partsMutex held, before writing any of it
+func showingOutput(out *Output) {
+	sideband.shown.Store(out)
+	flushSideband()
+}
+
+// flushSidebandAfter writes out what's been queued for the terminal once the output being shown is at a boundary.
+// Has to be called with its partsMutex held, after writing to the terminal
+func flushSidebandAfter(out *Output) {
+	if sideband.queued.Load() && out.termModes.atBoundary() {
+		flushSideband()
+	}
+}
+
+// flushSideband writes out everything queued, which must only be done when the output being shown can't be
+// written at the same time
+func flushSideband() {
+	sideband.mutex.Lock()
+	defer sideband.mutex.Unlock()
+
+	if len(sideband.pending) == 0 {
+		return
+	}
+	_, _ = os.Stdout.Write(sideband.pending)
+	sideband.pending = sideband.pending[:0]
+	sideband.queued.Store(false)
+}
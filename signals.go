@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// interruptGraceWindow is how soon another ^C has to follow the previous one to escalate to the next stage
+const interruptGraceWindow = 3 * time.Second
+
+// every child that has been started and not yet waited for
+var running = struct {
+	mutex      sync.Mutex
+	processes  map[*ProcessResult]struct{}
+	foreground *ProcessResult
+}{
+	processes: map[*ProcessResult]struct{}{},
+}
+
+func addRunning(proc *ProcessResult) {
+	running.mutex.Lock()
+	defer running.mutex.Unlock()
+
+	running.processes[proc] = struct{}{}
+}
+
+func removeRunning(proc *ProcessResult) {
+	running.mutex.Lock()
+	defer running.mutex.Unlock()
+
+	delete(running.processes, proc)
+	if running.foreground == proc {
+		running.foreground = nil
+	}
+}
+
+func setForeground(proc *ProcessResult) {
+	running.mutex.Lock()
+	defer running.mutex.Unlock()
+
+	running.foreground = proc
+}
+
+func signalForeground(sig syscall.Signal) {
+	running.mutex.Lock()
+	defer running.mutex.Unlock()
+
+	if running.foreground != nil {
+		running.foreground.signal(sig)
+	}
+}
+
+func signalAllRunning(sig syscall.Signal) {
+	running.mutex.Lock()
+	defer running.mutex.Unlock()
+
+	for proc := range running.processes {
+		proc.signal(sig)
+	}
+}
+
+// terminate asks a process to stop with the --signal signal, and kills it if it doesn't within --kill-after
+func terminate(proc *ProcessResult) {
+	proc.signal(parsedFlSignal)
+
+	if *flKillAfter > 0 {
+		go func() {
+			select {
+			case <-proc.finished:
+			case <-time.After(*flKillAfter):
+				proc.signal(syscall.SIGKILL)
+			}
+		}()
+	}
+}
+
+func terminateAllRunning() {
+	running.mutex.Lock()
+	defer running.mutex.Unlock()
+
+	for proc := range running.processes {
+		terminate(proc)
+	}
+}
+
+// handleInterrupts makes ^C escalate gradually: the first one only interrupts the foreground child and stops
+// spawning new ones, the second terminates every child, and only the third one kills everything and aborts the run
+func handleInterrupts(restoreTerminal func()) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, syscall.SIGINT)
+
+	go func() {
+		stage := 0
+		var lastInterrupt time.Time
+
+		for range interrupts {
+			if time.Since(lastInterrupt) > interruptGraceWindow {
+				stage = 0
+			}
+			lastInterrupt = time.Now()
+			stage += 1
+
+			switch stage {
+			case 1:
+				stopSpawning()
+				signalForeground(syscall.SIGINT)
+			case 2:
+				terminateAllRunning()
+			default:
+				signalAllRunning(syscall.SIGKILL)
+				abortWith(130, restoreTerminal)
+			}
+		}
+	}()
+}
+
+// signal sends a signal to the whole process group of a child, so that everything it has spawned gets it as well.
+// Every child is the leader of its own process group - either as a session leader when it gets a pty, or by
+// Setpgid when using pipes
+func (proc *ProcessResult) signal(sig syscall.Signal) {
+	if proc.cmd == nil {
+		// never started
+		return
+	}
+
+	err := syscall.Kill(-proc.cmd.Process.Pid, sig)
+	if err != nil {
+		_ = proc.cmd.Process.Signal(sig)
+	}
+}
+
+// ignoreGroupSignals keeps us alive when the process group we share with the wrapped command gets signalled - the
+// command gets the same signal on its own, and we still have to wait for it to flush the tty afterwards
+func ignoreGroupSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for range signals {
+		}
+	}()
+}
+
+// shutdownSignal is the signal that has asked us to shut down, or 0 if none has
+var shutdownSignal = atomic.Int32{}
+
+// handleShutdown makes SIGTERM and SIGHUP stop the run gracefully: nothing new gets spawned, every child gets the
+// same signal and --shutdown-grace to exit before getting killed, while output collected so far still gets shown.
+// Getting another one of those signals aborts the run, dropping what hasn't been shown yet.
+func handleShutdown(restoreTerminal func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+
+	go func() {
+		sig := (<-signals).(syscall.Signal)
+
+		shutdownSignal.Store(int32(sig))
+		stopSpawning()
+		signalAllRunning(sig)
+
+		select {
+		case <-time.After(*flShutdownGrace):
+			signalAllRunning(syscall.SIGKILL)
+		case anotherSig := <-signals:
+			signalAllRunning(syscall.SIGKILL)
+			abortWith(128+int(anotherSig.(syscall.Signal)), restoreTerminal)
+			return
+		}
+
+		sig = (<-signals).(syscall.Signal)
+		abortWith(128+int(sig), restoreTerminal)
+	}()
+}
+
+// abortGrace is how long an aborted run gets to end on its own before we exit anyway - showing the output of a
+// command can be stuck writing to a terminal that isn't being read
+const abortGrace = 2 * time.Second
+
+// abortWith cancels executing, for displaySequentially to stop showing output and return exitCode
+func abortWith(exitCode int, restoreTerminal func()) {
+	abort(exitStatus(exitCode))
+
+	time.AfterFunc(abortGrace, func() {
+		restoreTerminal()
+		os.Exit(exitCode)
+	})
+}
+
+// dieBySignal makes us die of the same signal our child has died of, so that our parent can tell what happened
+func dieBySignal(sig syscall.Signal) {
+	if resetSignalToDefault(sig) {
+		_ = syscall.Kill(os.Getpid(), sig)
+
+		// the signal gets delivered asynchronously - give it a moment before falling back to the shell convention
+		time.Sleep(time.Second)
+	}
+	os.Exit(128 + int(sig))
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// resetSignalToDefault sets the disposition of a signal to SIG_DFL behind the back of the Go runtime, so that the
+// kernel itself applies the default action when the signal arrives
+func resetSignalToDefault(sig syscall.Signal) (ok bool) {
+	// struct sigaction - all zeroes mean SIG_DFL with no flags. Made larger than it is on any architecture.
+	var sigaction [8]uint64
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_RT_SIGACTION, uintptr(sig), uintptr(unsafe.Pointer(&sigaction)), 0, 8, 0, 0)
+	return errno == 0
+}
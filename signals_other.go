@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+func resetSignalToDefault(sig syscall.Signal) (ok bool) {
+	return false
+}
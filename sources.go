@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// inputSource hands out the jobs to run one by one, returning io.EOF once there are no more of them. Which sources
+// get used is decided by the flags in main - everything after that doesn't care where jobs are coming from
+type inputSource interface {
+	next() (*Job, error)
+}
+
+// startProcessesFrom spawns every job of an input source, until it runs out of them or we stop spawning children
+func startProcessesFrom(source inputSource, result chan<- *ProcessResult) {
+	if *flInputBuffer > 0 {
+		source = newReadAheadSource(source, *flInputBuffer)
+	}
+	if *flDedup {
+		source = newDedupSource(source)
+	}
+	if *flShard != "" {
+		source = &shardedSource{inputSource: source}
+	}
+	if *flSortBySize || *flSortByCost != "" {
+		source = newCostSortedSource(source)
+	}
+	if *flGroupBy != "" {
+		source = newGroupedSource(source)
+	}
+	if *flDiffOutputs {
+		source = &variantsSource{inputSource: source}
+	}
+
+	var estimate runEstimate
+	if *flEstimate > 0 {
+		jobs := readAllJobs(source)
+		estimate.total = len(jobs)
+		source = &jobsSource{jobs: jobs}
+	}
+
+	var canaries canaryJobs
+	var window spawnWindow
+	for spawning.Err() == nil && waitWhilePaused() && window.wait() && waitForPowerBudget() {
+		job, err := source.next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalf("Could not get the next command to run: %v\n", err)
+		}
+		proc := spawn(result, job)
+		window.add(proc)
+		if !canaries.add(proc) {
+			stopSpawning()
+			return
+		}
+		estimate.add(proc)
+	}
+}
+
+// cliArgumentsSource makes jobs out of the arguments given after ":::"
+type cliArgumentsSource struct {
+	command []string
+	batches [][]string
+	total   int
+	tee     *stdinBroadcast
+}
+
+func newCliArgumentsSource(args Args) *cliArgumentsSource {
+	source := &cliArgumentsSource{
+		command: args.command,
+		batches: batchArguments(args.command, args.data),
+	}
+	source.total = len(source.batches)
+	if *flTee {
+		source.tee = newStdinBroadcast(len(source.batches))
+		go source.tee.run()
+	}
+	return source
+}
+
+func (source *cliArgumentsSource) next() (*Job, error) {
+	if len(source.batches) == 0 {
+		return nil, io.EOF
+	}
+
+	job := newJob(source.command, source.batches[0]...)
+	job.total = source.total
+	if source.tee != nil {
+		job.stdin = source.tee.newConsumer()
+	}
+
+	source.batches = source.batches[1:]
+	return job, nil
+}
+
+// linesSource makes jobs out of lines (or with --null, NUL-terminated records) of stdin or --arg-file
+type linesSource struct {
+	command []string
+	reader  *argumentReader
+	batcher argumentBatcher
+	ready   [][]string
+	atEOF   bool
+}
+
+func (source *linesSource) next() (*Job, error) {
+	for len(source.ready) == 0 {
+		if source.atEOF {
+			return nil, io.EOF
+		}
+
+		items, err := source.reader.next()
+		source.ready = source.batcher.add(items)
+		if err == io.EOF {
+			source.ready = append(source.ready, source.batcher.finish()...)
+			source.atEOF = true
+		}
+	}
+
+	job := newJob(source.command, source.ready[0]...)
+	source.ready = source.ready[1:]
+	return job, nil
+}
+
+// csvSource makes a job out of every record of CSV input, with the fields of the record as its arguments
+type csvSource struct {
+	command []string
+	reader  *csv.Reader
+}
+
+func (source *csvSource) next() (*Job, error) {
+	record, err := source.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	return newJob(source.command, record...), nil
+}
+
+// newLinesSource reads the arguments of commands from stdin or --arg-file, one record at a time
+func newLinesSource(command []string, input io.Reader) inputSource {
+	if *flCsv {
+		reader := csv.NewReader(input)
+		reader.FieldsPerRecord = -1
+		return &csvSource{command: command, reader: reader}
+	}
+
+	return &linesSource{
+		command: command,
+		reader:  newArgumentReader(input),
+		batcher: argumentBatcher{command: command},
+	}
+}
+
+// what we exit with when there haven't been any arguments at all, with --fail-if-empty
+const emptyInputExitCode = 122
+
+// gotAnyInput is set once any source of arguments has given a job
+var gotAnyInput = atomic.Bool{}
+
+// runIfEmptySource notes whether its source has got any jobs, and with --run-if-empty runs the command once without
+// any arguments if it hasn't. Without it, no arguments means nothing gets run
+type runIfEmptySource struct {
+	inputSource
+	command []string
+	any     bool
+}
+
+func withRunIfEmpty(command []string, source inputSource) inputSource {
+	return &runIfEmptySource{inputSource: source, command: command}
+}
+
+func (source *runIfEmptySource) next() (*Job, error) {
+	job, err := source.inputSource.next()
+	if err == io.EOF && !source.any && *flRunIfEmpty {
+		source.any = true
+		return newJob(source.command), nil
+	}
+	if err == nil {
+		gotAnyInput.Store(true)
+	}
+	source.any = true
+	return job, err
+}
+
+// jobsSource hands out jobs that have already been read from another source
+type jobsSource struct {
+	jobs []*Job
+}
+
+func readAllJobs(source inputSource) (jobs []*Job) {
+	for {
+		job, err := source.next()
+		if err == io.EOF {
+			return jobs
+		}
+		if err != nil {
+			log.Fatalf("Could not get the next command to run: %v\n", err)
+		}
+		jobs = append(jobs, job)
+	}
+}
+
+func (source *jobsSource) next() (*Job, error) {
+	if len(source.jobs) == 0 {
+		return nil, io.EOF
+	}
+
+	job := source.jobs[0]
+	source.jobs = source.jobs[1:]
+	return job, nil
+}
+
+// readAheadSource keeps reading jobs of another source in the background, holding at most a given number of them
+type readAheadSource struct {
+	jobs chan *Job
+	err  error
+}
+
+func newReadAheadSource(source inputSource, size int) *readAheadSource {
+	// the one the reading goroutine is waiting to put in the channel counts as well
+	readAhead := &readAheadSource{jobs: make(chan *Job, size-1)}
+	go func() {
+		defer close(readAhead.jobs)
+		for {
+			job, err := source.next()
+			if err != nil {
+				readAhead.err = err
+				return
+			}
+			readAhead.jobs <- job
+		}
+	}()
+	return readAhead
+}
+
+func (source *readAheadSource) next() (*Job, error) {
+	job, ok := <-source.jobs
+	if !ok {
+		return nil, source.err
+	}
+	return job, nil
+}
+
+// submissionsSource hands out the jobs sent with --submit, until there won't be any more of them
+type submissionsSource struct{}
+
+func (submissionsSource) next() (*Job, error) {
+	job, ok := <-submissions.jobs.Out()
+	if !ok {
+		return nil, io.EOF
+	}
+	return job, nil
+}
+
+func startProcessesFromArgFile(args Args, result chan<- *ProcessResult) {
+	file, err := os.Open(*flArgFile)
+	if err != nil {
+		log.Fatalf("Could not open --arg-file: %v\n", err)
+	}
+	defer haveToClose("--arg-file", file)
+
+	startProcessesFrom(withRunIfEmpty(args.command, newLinesSource(args.command, file)), result)
+}
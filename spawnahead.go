@@ -0,0 +1,40 @@
+package main
+
+// spawnWindow keeps track of the commands started with --spawn-ahead, so that the next one only gets started once
+// it isn't too far ahead of the oldest one still running
+type spawnWindow struct {
+	started []*ProcessResult
+}
+
+// wait blocks until the next command can be started. Returns false if we've stopped spawning in the meantime
+func (w *spawnWindow) wait() bool {
+	for *flSpawnAhead > 0 && len(w.started) >= *flSpawnAhead {
+		select {
+		case <-w.started[0].finished:
+			w.started = w.started[1:]
+		case <-spawning.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func (w *spawnWindow) add(proc *ProcessResult) {
+	if proc != nil && *flSpawnAhead > 0 {
+		w.started = append(w.started, proc)
+	}
+}
+
+// stopSpawningOnFailure stops starting commands as soon as one has failed, instead of once its output gets shown -
+// everything started after it would only get terminated then
+func stopSpawningOnFailure(proc *ProcessResult) {
+	// when shutting down, every child is going to fail
+	if proc.finalExitCode == 0 || shutdownSignal.Load() != 0 || deadlineExceeded.Load() {
+		return
+	}
+
+	if spawning.Err() == nil {
+		verbosef(1, "#%d has failed, not starting any more commands", proc.seq)
+	}
+	stopSpawning()
+}
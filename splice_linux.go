@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// spliceToParent moves the output of a child in the foreground from its pipe straight to our stdout/stderr, without
+// copying it through userspace. Returns spliced=false if that can't be done, having moved nothing - otherwise it
+// keeps going until reading fails, and returns why (io.EOF when the child has closed its end).
+func spliceToParent(stream *os.File, out *Output, fileDescriptor int) (spliced bool, err error) {
+	destination := standardFdToFile[fileDescriptor]
+
+	// splice on a non-blocking destination would return EAGAIN, and we can only wait for the source to be readable
+	flags, err := unix.FcntlInt(destination.Fd(), unix.F_GETFL, 0)
+	if err != nil || flags&unix.O_NONBLOCK != 0 {
+		return false, nil
+	}
+
+	rawConn, err := stream.SyscallConn()
+	if err != nil {
+		return false, nil
+	}
+
+	for {
+		var moved int64
+		var spliceErr error
+		err := rawConn.Read(func(fd uintptr) (done bool) {
+			for {
+				moved, spliceErr = unix.Splice(int(fd), nil, int(destination.Fd()), nil, MAXBUF, unix.SPLICE_F_MOVE)
+				if !errors.Is(spliceErr, unix.EAGAIN) {
+					return true
+				}
+				if !waitForDestination(int(fd), int(destination.Fd())) {
+					// nothing to read - let the poller wait for the child to write something
+					return false
+				}
+			}
+		})
+		if err != nil {
+			return true, err
+		}
+
+		if errors.Is(spliceErr, unix.EINVAL) && !spliced {
+			// one of the ends doesn't support splicing - like a file opened with O_APPEND
+			return false, nil
+		}
+		if errors.Is(spliceErr, unix.EINTR) {
+			continue
+		}
+		if spliceErr != nil {
+			return true, &os.PathError{Op: "splice", Path: stream.Name(), Err: spliceErr}
+		}
+		if moved == 0 {
+			return true, io.EOF
+		}
+
+		spliced = true
+		out.lastActivity.Store(time.Now().UnixNano())
+	}
+}
+
+// waitForDestination handles splice returning EAGAIN while the source still has data in it. As our source pipe is
+// non-blocking that EAGAIN can just as well mean the destination is full - so block until it no longer is.
+func waitForDestination(source int, destination int) (sourceHasData bool) {
+	available, err := unix.IoctlGetInt(source, unix.TIOCINQ)
+	if err != nil || available == 0 {
+		return false
+	}
+
+	fds := []unix.PollFd{{Fd: int32(destination), Events: unix.POLLOUT}}
+	for {
+		_, err := unix.Poll(fds, -1)
+		if !errors.Is(err, unix.EINTR) {
+			return true
+		}
+	}
+}
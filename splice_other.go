@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+func spliceToParent(stream *os.File, out *Output, fileDescriptor int) (spliced bool, err error) {
+	return false, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alessio/shellescape"
+)
+
+// watchForStalls terminates (or just warns about) a child that hasn't written anything for --stall-timeout
+func (proc *ProcessResult) watchForStalls() {
+	out := proc.output
+	var warnedAt int64
+
+	for {
+		lastActivity := out.lastActivity.Load()
+		if out.waitingForMemory.Load() {
+			// it's us who's not reading the child's output, the child might be just blocked writing it
+			lastActivity = time.Now().UnixNano()
+		}
+
+		idle := time.Since(time.Unix(0, lastActivity))
+
+		if idle >= *flStallTimeout && warnedAt != lastActivity {
+			warnedAt = lastActivity
+
+			if *flOnStall == "warn" {
+				out.appendNoticeWithoutWaiting(fmt.Sprintf("%s hasn't written anything for %v",
+					shellescape.QuoteCommand(proc.originalCommand),
+					idle.Round(time.Second)))
+			} else {
+				out.appendNoticeWithoutWaiting(fmt.Sprintf("%s hasn't written anything for %v, terminating it",
+					shellescape.QuoteCommand(proc.originalCommand),
+					idle.Round(time.Second)))
+				terminate(proc)
+				return
+			}
+		}
+
+		nextCheck := *flStallTimeout - idle
+		if nextCheck <= 0 {
+			nextCheck = *flStallTimeout
+		}
+
+		select {
+		case <-proc.finished:
+			return
+		case <-time.After(nextCheck):
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// stdinRouter passes everything typed into our terminal to the pty of the process that's currently in the
+// foreground, so interactive prompts (passwords, confirmations) in children can be answered
+var stdinRouter = struct {
+	mutex  sync.Mutex
+	target *os.File
+
+	// whether --control-key has just been pressed, only used by the goroutine reading stdin
+	controlPending bool
+}{}
+
+func stdinIsTty() bool {
+	return term.IsTerminal(syscall.Stdin)
+}
+
+func shouldForwardStdin() bool {
+	return *flForwardStdin && !*flFromStdin && !*flTee && usePtys() && stdinIsTty()
+}
+
+// startForwardingStdin puts the terminal into raw mode, so that keys (including ^C and ^D) reach the foreground
+// child unprocessed - its own pty is the one responsible for echoing and line editing. Only ^Z and --control-key are
+// ours
+func startForwardingStdin() (originalStdinState *term.State) {
+	originalStdinState, err := term.MakeRaw(syscall.Stdin)
+	if err != nil {
+		log.Printf("Warning: could not put stdin into raw mode, not forwarding it to children: %v\n", err)
+		return nil
+	}
+
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			count, err := os.Stdin.Read(buffer)
+			if count > 0 {
+				routeKeys(buffer[:count])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return originalStdinState
+}
+
+// routeKeys forwards what's been typed to the foreground child, except for ^Z and --control-key with what follows it
+func routeKeys(data []byte) {
+	start := 0
+	for i, key := range data {
+		if !stdinRouter.controlPending && key != suspendCharacter && (parsedControlKey == 0 || key != parsedControlKey) {
+			continue
+		}
+
+		forwardToForeground(data[start:i])
+		start = i + 1
+
+		switch {
+		case stdinRouter.controlPending:
+			stdinRouter.controlPending = false
+			controlForeground(key)
+		case key == suspendCharacter:
+			_ = syscall.Kill(os.Getpid(), syscall.SIGTSTP)
+		default:
+			stdinRouter.controlPending = true
+		}
+	}
+	forwardToForeground(data[start:])
+}
+
+func forwardToForeground(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	stdinRouter.mutex.Lock()
+	defer stdinRouter.mutex.Unlock()
+
+	if stdinRouter.target == nil {
+		// nobody to receive it - the foreground process has just finished and the next one isn't attached yet
+		return
+	}
+
+	// the child may have already exited and its pty may be closed, that's fine
+	_, _ = stdinRouter.target.Write(data)
+}
+
+func attachStdin(out *Output) {
+	stdinRouter.mutex.Lock()
+	defer stdinRouter.mutex.Unlock()
+
+	stdinRouter.target = out.stdinPty
+}
+
+func detachStdin() {
+	stdinRouter.mutex.Lock()
+	defer stdinRouter.mutex.Unlock()
+
+	stdinRouter.target = nil
+}
+
+func restoreStdinState(originalStdinState *term.State) {
+	if originalStdinState == nil {
+		return
+	}
+	err := term.Restore(syscall.Stdin, originalStdinState)
+	if err != nil {
+		log.Printf("Warning: could not restore stdin terminal state on exit: %v\n", err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	flag "github.com/spf13/pflag"
+	"golang.org/x/exp/slices"
+)
+
+// a subcommand is another way of spelling one of the modes selected by flags: `gparallel wait` is `gparallel --wait`.
+// Running commands is the default, so `gparallel run` can be left out - unless the command itself is called like
+// one of the subcommands. Those which never get ":::" are told apart from a command on their own: `gparallel wait
+// ::: a` runs wait. For the others, it takes `gparallel run queue ::: a` or `gparallel -- queue ::: a`
+type subcommand struct {
+	usage       string
+	description string
+	translate   func(args []string) []string
+
+	// whether ":::" can be given to it - when it can't, a ":::" makes the first word the command to run instead
+	takesTripleColon bool
+
+	// for subcommands that aren't a mode of running commands, and are done right away instead
+	execute func(args []string)
+}
+
+var subcommands = map[string]subcommand{
+	"run": {
+		usage:       "run [flags] command [arguments] ::: arguments",
+		description: "Run a command for every argument (what happens without a subcommand as well).",
+		translate:   func(args []string) []string { return args },
+
+		takesTripleColon: true,
+	},
+	"wait": {
+		usage:       "wait [flags]",
+		description: "Execute and wait for commands queued for us using `queue`.",
+		translate:   func(args []string) []string { return append([]string{"--wait"}, args...) },
+	},
+	"queue": {
+		usage:       queueUsage,
+		description: "Queue a command for an ancestor process (by default our parent) to later execute with `wait`.",
+		translate:   translateQueueSubcommand,
+
+		takesTripleColon: true,
+	},
+	"show-queue": {
+		usage:       "show-queue",
+		description: "Show every queued command for every process - useful for debugging missing `wait` calls.",
+		translate:   func(args []string) []string { return append([]string{"--show-queue"}, args...) },
+	},
+	"daemon": {
+		usage:       "daemon [flags]",
+		description: "Run commands sent with `submit` one after another (or -P at once), until an empty `submit` - picking up what's left after a restart.",
+		translate: func(args []string) []string {
+			return append([]string{"--listen", daemonSocketPath(), "--persist", daemonQueuePath(), "--keep-going-on-error", "-P", "1"}, args...)
+		},
+	},
+	"submit": {
+		usage:       "submit [command [arguments] | ::: arguments]",
+		description: "Send a command, or arguments for its command, to the `daemon`. With nothing to send, stop it once it's done.",
+		translate: func(args []string) []string {
+			return append([]string{"--submit", daemonSocketPath(), "--"}, args...)
+		},
+
+		takesTripleColon: true,
+	},
+	"completion": {
+		usage:       "completion bash|zsh|fish",
+		description: "Print a script making the shell complete gparallel's flags, and the commands run with it.",
+		execute:     printCompletion,
+	},
+	"bench": {
+		usage:       "bench [--size size] [--raw-buffer mode]",
+		description: "Measure how fast output goes through gparallel, for working on gparallel itself.",
+		execute:     runBench,
+	},
+}
+
+// the order subcommands get listed in the usage - bench is left out on purpose
+var subcommandNames = []string{"run", "wait", "queue", "show-queue", "daemon", "submit", "completion"}
+
+func usageOfSubcommands() {
+	_, _ = fmt.Fprintf(os.Stderr, "Subcommands:\n")
+	for _, name := range subcommandNames {
+		_, _ = fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, subcommands[name].description)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "\nA command called like a subcommand gets run when followed by \":::\" - except for run, queue\nand submit, which need `run` or `--` before it.\n\n")
+}
+
+const queueUsage = "queue [--pid pid | --ancestor process-name] [--slurp-stdin] command [arguments]"
+
+// queue has its own flags, instead of the three mutually exclusive --queue-command-* ones
+func translateQueueSubcommand(args []string) []string {
+	queueFlags := flag.NewFlagSet("queue", flag.ContinueOnError)
+	queueFlags.SetInterspersed(false)
+	pid := queueFlags.Int("pid", -1, "Queue the command for a specific ancestor `pid`.")
+	ancestor := queueFlags.String("ancestor", "", "Queue the command for the closest ancestor process with a `name`.")
+	slurpStdin := queueFlags.Bool("slurp-stdin", false, "Read all available stdin and pass it onto the command.")
+	help := queueFlags.BoolP("help", "h", false, "Show this help message.")
+	queueFlags.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: %s %s\n\n", os.Args[0], queueUsage)
+		queueFlags.PrintDefaults()
+	}
+
+	if err := queueFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if *help {
+		queueFlags.Usage()
+		os.Exit(0)
+	}
+	if *pid != -1 && *ancestor != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: Argument error: cannot specify both --pid and --ancestor\n\n", os.Args[0])
+		queueFlags.Usage()
+		os.Exit(1)
+	}
+
+	var translated []string
+	switch {
+	case *pid != -1:
+		translated = append(translated, "--queue-command-pid", fmt.Sprint(*pid))
+	case *ancestor != "":
+		translated = append(translated, "--queue-command-ancestor", *ancestor)
+	default:
+		translated = append(translated, "--queue-command")
+	}
+	if *slurpStdin {
+		translated = append(translated, "--slurp-stdin")
+	}
+
+	return append(append(translated, "--"), queueFlags.Args()...)
+}
+
+func daemonSocketPath() string {
+	path := filepath.Join(dataDir(), "daemon", "socket")
+	if err := os.MkdirAll(filepath.Dir(path), fs.ModePerm); err != nil {
+		log.Fatalf("Couldn't create directory '%s': %v\n", filepath.Dir(path), err)
+	}
+	return path
+}
+
+// the queue of the daemon has to survive a reboot, unlike everything else in dataDir()
+func daemonQueuePath() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		log.Fatalf("Could not find a place for the queue of the daemon: %v\n", err)
+	}
+	return filepath.Join(cacheDir, "gparallel", "daemon-queue")
+}
+
+// applySubcommand rewrites a subcommand given as the first argument into the flags selecting its mode
+func applySubcommand() {
+	if len(os.Args) < 2 {
+		return
+	}
+
+	sub, ok := subcommands[os.Args[1]]
+	if !ok || (!sub.takesTripleColon && slices.Contains(os.Args[2:], ":::")) {
+		return
+	}
+
+	if sub.execute != nil {
+		sub.execute(os.Args[2:])
+		os.Exit(0)
+	}
+
+	os.Args = append(os.Args[:1], sub.translate(os.Args[2:])...)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/alessio/shellescape"
+)
+
+// how many of the most expensive jobs get listed in the summary
+const summaryTopJobs = 5
+
+type jobUsage struct {
+	command  []string
+	exitCode int
+	killedBy syscall.Signal
+	wallTime time.Duration
+	userTime time.Duration
+	sysTime  time.Duration
+	maxRss   int64 // in bytes
+	inBlock  int64
+	outBlock int64
+}
+
+func (usage *jobUsage) cpuTime() time.Duration {
+	return usage.userTime + usage.sysTime
+}
+
+var summary = struct {
+	startedAt time.Time
+	jobs      []jobUsage
+}{
+	startedAt: time.Now(),
+}
+
+// resourceUsage gets the rusage of a child as returned by wait4 - which on most systems also covers the descendants
+// it has waited for itself
+func (proc *ProcessResult) resourceUsage() (usage jobUsage) {
+	usage.command = proc.originalCommand
+	usage.killedBy = proc.killedBy
+	usage.wallTime = proc.finishedAt.Sub(proc.startedAt)
+
+	if proc.cmd == nil || proc.cmd.ProcessState == nil {
+		return usage
+	}
+
+	usage.userTime = proc.cmd.ProcessState.UserTime()
+	usage.sysTime = proc.cmd.ProcessState.SystemTime()
+
+	if rusage, ok := proc.cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+		usage.maxRss = int64(rusage.Maxrss)
+		if runtime.GOOS != "darwin" {
+			// everywhere but on macOS, ru_maxrss is in kilobytes
+			usage.maxRss *= 1024
+		}
+		usage.inBlock = int64(rusage.Inblock)
+		usage.outBlock = int64(rusage.Oublock)
+	}
+
+	return usage
+}
+
+func recordFinishedJob(proc *ProcessResult, exitCode int) {
+	usage := proc.resourceUsage()
+	usage.exitCode = exitCode
+	summary.jobs = append(summary.jobs, usage)
+}
+
+// countFailedJobs counts the jobs that have exited unsuccessfully, and those of them killed by a signal
+func countFailedJobs() (failed, killed int) {
+	for _, job := range summary.jobs {
+		if job.exitCode != 0 {
+			failed += 1
+		}
+		if job.killedBy != 0 {
+			killed += 1
+		}
+	}
+	return failed, killed
+}
+
+func printSummary() {
+	failed, killed := countFailedJobs()
+	var userTime, sysTime time.Duration
+	for _, job := range summary.jobs {
+		userTime += job.userTime
+		sysTime += job.sysTime
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "\n%s %d jobs, %d failed (%d killed by a signal), took %v (user %v, sys %v)\n",
+		bold("Summary:"),
+		len(summary.jobs),
+		failed,
+		killed,
+		time.Since(summary.startedAt).Round(time.Millisecond),
+		userTime.Round(time.Millisecond),
+		sysTime.Round(time.Millisecond))
+
+	if len(summary.jobs) < 2 {
+		return
+	}
+
+	printTopJobs("Longest running:", func(a, b *jobUsage) bool { return a.wallTime > b.wallTime }, func(job *jobUsage) string {
+		return fmt.Sprintf("%10v", job.wallTime.Round(time.Millisecond))
+	})
+	printTopJobs("Most CPU time:", func(a, b *jobUsage) bool { return a.cpuTime() > b.cpuTime() }, func(job *jobUsage) string {
+		return fmt.Sprintf("%10v", job.cpuTime().Round(time.Millisecond))
+	})
+	printTopJobs("Highest max RSS:", func(a, b *jobUsage) bool { return a.maxRss > b.maxRss }, func(job *jobUsage) string {
+		return fmt.Sprintf("%6.1f MiB", float64(job.maxRss)/1024/1024)
+	})
+	printTopJobs("Most block IO operations (in/out):", func(a, b *jobUsage) bool {
+		return a.inBlock+a.outBlock > b.inBlock+b.outBlock
+	}, func(job *jobUsage) string {
+		return fmt.Sprintf("%5d/%-5d", job.inBlock, job.outBlock)
+	})
+}
+
+func printTopJobs(title string, more func(a, b *jobUsage) bool, format func(job *jobUsage) string) {
+	jobs := append([]jobUsage{}, summary.jobs...)
+	sort.SliceStable(jobs, func(i, j int) bool { return more(&jobs[i], &jobs[j]) })
+
+	_, _ = fmt.Fprintln(os.Stderr, bold(title))
+	for i := range jobs[:min(len(jobs), summaryTopJobs)] {
+		_, _ = fmt.Fprintf(os.Stderr, "  %s  %s\n", format(&jobs[i]), shellescape.QuoteCommand(jobs[i].command))
+	}
+}
+
+// printChecksum shows the SHA-256 of the stdout of a finished command, the same way sha256sum does for files
+func printChecksum(proc *ProcessResult) {
+	checksum := proc.output.stdoutChecksum
+	if checksum == nil {
+		// never started
+		checksum = sha256.New()
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "%x  %s\n", checksum.Sum(nil), shellescape.QuoteCommand(proc.originalCommand))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// what ^Z sends, which with stdin in raw mode reaches us as a byte instead of a SIGTSTP
+const suspendCharacter = 0x1a
+
+// handleSuspend makes ^Z suspend the whole run, like it would suspend a single command: every child gets stopped
+// along with us, and continued when we are. Children run in sessions or process groups of their own, so they
+// don't get the SIGTSTP of the terminal by themselves - and being in orphaned process groups, would ignore it anyway
+func handleSuspend(originalStdinState *term.State) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTSTP)
+
+	go func() {
+		for range signals {
+			suspend(originalStdinState)
+		}
+	}()
+}
+
+func suspend(originalStdinState *term.State) {
+	debugf("term", "suspending every child and ourselves")
+	signalAllRunning(syscall.SIGSTOP)
+	restoreStdinState(originalStdinState)
+
+	continued := make(chan os.Signal, 1)
+	signal.Notify(continued, syscall.SIGCONT)
+	defer signal.Stop(continued)
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+	<-continued
+
+	debugf("term", "continued, continuing every child")
+	if originalStdinState != nil {
+		if _, err := term.MakeRaw(syscall.Stdin); err != nil {
+			log.Printf("Warning: could not put stdin back into raw mode: %v\n", err)
+		}
+	}
+	signalAllRunning(syscall.SIGCONT)
+
+	// the terminal might have been resized while we weren't its foreground process group
+	_ = syscall.Kill(os.Getpid(), syscall.SIGWINCH)
+}
@@ -0,0 +1,10 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// tcdrain waits for everything written to a terminal to be transmitted
+func tcdrain(fd int) error {
+	return unix.IoctlSetInt(fd, unix.TIOCDRAIN, 0)
+}
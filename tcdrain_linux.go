@@ -0,0 +1,8 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// tcdrain waits for everything written to a terminal to be transmitted, like tcdrain(3) of glibc does
+func tcdrain(fd int) error {
+	return unix.IoctlSetInt(fd, unix.TCSBRK, 1)
+}
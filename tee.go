@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// stdinBroadcast reads our stdin once and writes everything to the stdin of every --tee job. It reads only as far
+// ahead as the fastest job has got, keeping what the others haven't been given yet - jobs can be held back from
+// starting (by the -P limit of a parent gparallel, --canary, --spawn-ahead, --flock and the like) while others are
+// already running, and have to get everything from the beginning once they start.
+type stdinBroadcast struct {
+	mutex   sync.Mutex
+	changed *sync.Cond
+
+	chunks  [][]byte // read, but not yet written to every job - the first one being chunk number dropped
+	dropped int
+	ended   bool
+
+	expected    int
+	positions   []int // the number of the next chunk for every job, or -1 once it has stopped reading
+	firstJoined chan struct{}
+}
+
+func newStdinBroadcast(expectedConsumers int) *stdinBroadcast {
+	broadcast := &stdinBroadcast{
+		expected:    expectedConsumers,
+		firstJoined: make(chan struct{}),
+	}
+	broadcast.changed = sync.NewCond(&broadcast.mutex)
+	if expectedConsumers == 0 {
+		close(broadcast.firstJoined)
+	}
+	return broadcast
+}
+
+// newConsumer returns a new stdin for a job, which gets everything read so far first
+func (broadcast *stdinBroadcast) newConsumer() *os.File {
+	broadcast.mutex.Lock()
+	defer broadcast.mutex.Unlock()
+
+	readEnd, writeEnd, err := os.Pipe()
+	if err != nil {
+		log.Fatalf("Could not create a pipe for --tee: %v\n", err)
+	}
+
+	broadcast.positions = append(broadcast.positions, 0)
+	if len(broadcast.positions) == 1 {
+		close(broadcast.firstJoined)
+	}
+	go broadcast.feed(len(broadcast.positions)-1, writeEnd)
+
+	return readEnd
+}
+
+func (broadcast *stdinBroadcast) run() {
+	<-broadcast.firstJoined
+
+	buffer := make([]byte, MAXBUF)
+	for {
+		broadcast.waitForFastestConsumer()
+
+		count, err := os.Stdin.Read(buffer)
+		if count > 0 {
+			broadcast.mutex.Lock()
+			broadcast.chunks = append(broadcast.chunks, bytes.Clone(buffer[:count]))
+			broadcast.dropWritten()
+			broadcast.changed.Broadcast()
+			broadcast.mutex.Unlock()
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatalf("Failed reading stdin for --tee: %v\n", err)
+		}
+	}
+
+	broadcast.mutex.Lock()
+	defer broadcast.mutex.Unlock()
+
+	broadcast.ended = true
+	broadcast.changed.Broadcast()
+}
+
+// waitForFastestConsumer blocks while every job still reading has something left to write to it
+func (broadcast *stdinBroadcast) waitForFastestConsumer() {
+	broadcast.mutex.Lock()
+	defer broadcast.mutex.Unlock()
+
+	end := broadcast.dropped + len(broadcast.chunks)
+	for {
+		reading, caughtUp := false, false
+		for _, position := range broadcast.positions {
+			reading = reading || position != -1
+			caughtUp = caughtUp || position == end
+		}
+		if !reading || caughtUp {
+			return
+		}
+		broadcast.changed.Wait()
+	}
+}
+
+// feed writes everything read from stdin to the stdin of one job, until it ends or the job stops reading it
+func (broadcast *stdinBroadcast) feed(consumer int, writeEnd *os.File) {
+	defer writeEnd.Close()
+
+	broadcast.mutex.Lock()
+	defer broadcast.mutex.Unlock()
+
+	for {
+		position := broadcast.positions[consumer]
+		if position == broadcast.dropped+len(broadcast.chunks) {
+			if broadcast.ended {
+				return
+			}
+			broadcast.changed.Wait()
+			continue
+		}
+
+		chunk := broadcast.chunks[position-broadcast.dropped]
+		broadcast.mutex.Unlock()
+		_, err := writeEnd.Write(chunk)
+		broadcast.mutex.Lock()
+
+		if err != nil {
+			// the job has closed its stdin (or exited)
+			broadcast.positions[consumer] = -1
+		} else {
+			broadcast.positions[consumer]++
+		}
+		broadcast.dropWritten()
+		broadcast.changed.Broadcast()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// dropWritten forgets the chunks every job has been given already - once all of them have joined, as the ones
+// joining late need everything. Has to be called with the mutex held
+func (broadcast *stdinBroadcast) dropWritten() {
+	if len(broadcast.positions) < broadcast.expected {
+		return
+	}
+
+	written := broadcast.dropped + len(broadcast.chunks)
+	for _, position := range broadcast.positions {
+		if position != -1 {
+			written = min(written, position)
+		}
+	}
+
+	for i := range broadcast.chunks[:written-broadcast.dropped] {
+		broadcast.chunks[i] = nil
+	}
+	broadcast.chunks = broadcast.chunks[written-broadcast.dropped:]
+	broadcast.dropped = written
+}
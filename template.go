@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+	"golang.org/x/exp/slices"
+)
+
+// replacement strings of GNU parallel that gparallel doesn't have, which would otherwise silently reach the command
+var foreignPlaceholder = regexp.MustCompile(`\{(\d+|\d*\.|\d*/|\d*//|\d*/\.|#|%|\+/|\+\.|\.\.|\+\.\.)\}`)
+
+func templatesFromFlag() {
+	parsedFlTemplates = nil
+	for _, template := range *flTemplates {
+		if template == "" && len(*flTemplates) > 1 {
+			errorWithUsage("-I (--replacement) cannot be empty when given more than once")
+		}
+		if slices.Contains(parsedFlTemplates, template) {
+			errorWithUsage("-I (--replacement) has been given '%s' twice", template)
+		}
+		if template != "" {
+			parsedFlTemplates = append(parsedFlTemplates, template)
+		}
+	}
+
+	// with --combine zip or product, the arguments of every command are made up by them
+	if len(parsedFlTemplates) > 1 && *flCombine != combineZip && *flCombine != combineProduct {
+		if flag.CommandLine.Changed("max-args") && *flMaxArgs != len(parsedFlTemplates) {
+			errorWithUsage("-n (--max-args) has to be %d with %d replacement strings, but got %d",
+				len(parsedFlTemplates), len(parsedFlTemplates), *flMaxArgs)
+		}
+		*flMaxArgs = len(parsedFlTemplates)
+	}
+}
+
+// argumentsOfTemplate tells what the i-th replacement string stands for: all of the arguments if there's just one,
+// otherwise the i-th argument (or nothing, if the input has run out of them)
+func argumentsOfTemplate(arguments []string, i int) []string {
+	if len(parsedFlTemplates) == 1 {
+		return arguments
+	}
+	if i >= len(arguments) {
+		return nil
+	}
+	return arguments[i : i+1]
+}
+
+// templateReplacer replaces every replacement string at once - so that arguments containing one of them don't get
+// replaced again
+func templateReplacer(arguments []string, join func([]string) string) *strings.Replacer {
+	var oldnew []string
+	for i, template := range parsedFlTemplates {
+		oldnew = append(oldnew, template, join(argumentsOfTemplate(arguments, i)))
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+func containsTemplate(word string) bool {
+	return slices.IndexFunc(parsedFlTemplates, func(template string) bool {
+		return strings.Contains(word, template)
+	}) != -1
+}
+
+// validateTemplate warns about mistakes in the command that would otherwise only show up in what gets run
+func validateTemplate(command []string) {
+	if len(command) == 0 {
+		return
+	}
+
+	if flag.CommandLine.Changed("replacement") {
+		var missing []string
+		for _, template := range parsedFlTemplates {
+			if slices.IndexFunc(command, func(word string) bool { return strings.Contains(word, template) }) == -1 {
+				missing = append(missing, template)
+			}
+		}
+
+		switch {
+		case len(missing) == 0:
+		case len(missing) == 1 && len(parsedFlTemplates) == 1:
+			log.Printf("Warning: the replacement string '%s' doesn't appear in the command, so arguments get appended to its end\n",
+				missing[0])
+		case len(missing) == len(parsedFlTemplates):
+			log.Printf("Warning: none of the replacement strings '%s' appear in the command, so arguments get appended to its end\n",
+				strings.Join(missing, "', '"))
+		default:
+			// the other replacement strings do get replaced, so nothing gets appended
+			for _, template := range missing {
+				log.Printf("Warning: the replacement string '%s' doesn't appear in the command, so the argument it stands for gets left out\n",
+					template)
+			}
+		}
+	}
+
+	var warned []string
+	for _, word := range command {
+		for _, placeholder := range foreignPlaceholder.FindAllString(word, -1) {
+			if slices.Contains(parsedFlTemplates, placeholder) || slices.Contains(warned, placeholder) {
+				continue
+			}
+			warned = append(warned, placeholder)
+			log.Printf("Warning: gparallel doesn't replace %s in the command, it gets passed on as it is - only '%s' gets replaced with the arguments\n",
+				placeholder, strings.Join(parsedFlTemplates, "', '"))
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// terminalModes keeps track of the terminal modes a child has switched away from their defaults, so that after it
+// exits we can put back only what it has changed, instead of resetting the whole terminal
+type terminalModes struct {
+	sgrSet        bool
+	cursorHidden  bool
+	appCursorKeys bool
+	appKeypad     bool
+	altScreen     bool
+
+	// whether the last text written (not counting escape sequences) hasn't ended with a new line - leaving the
+	// cursor after it
+	midLine bool
+
+	// separate parser state for every fd - stdout and stderr might come from separate ptys, so an escape
+	// sequence started on one of them can't be continued on the other
+	parsers [3]escapeParser
+}
+
+type escapeParserState int
+
+const (
+	parserGround escapeParserState = iota
+	parserEscape
+	parserCsi
+	parserString // OSC, DCS and the like, ended by BEL or ST
+	parserStringEscape
+)
+
+type escapeParser struct {
+	state  escapeParserState
+	params []byte
+
+	// whether the last text written ends in the middle of a UTF-8 character
+	partialRune bool
+}
+
+// maxCsiParamsLength caps how much of a malformed CSI sequence we're willing to hold on to
+const maxCsiParamsLength = 64
+
+func (modes *terminalModes) observe(fd int, data []byte) {
+	if *flRawBuffer == "always" {
+		return
+	}
+
+	parser := &modes.parsers[fd]
+	defer func() {
+		parser.partialRune = parser.state == parserGround && endsMidRune(data)
+	}()
+	if parser.state == parserGround && *flRawBuffer == "auto" {
+		// text without escape sequences can't change any modes - no need to go through it byte by byte
+		escape := bytes.IndexByte(data, '\x1b')
+		if escape == -1 {
+			modes.followText(data)
+			return
+		}
+		modes.followText(data[:escape])
+		data = data[escape:]
+	}
+
+	for _, b := range data {
+		switch parser.state {
+		case parserGround:
+			if b == '\x1b' {
+				parser.state = parserEscape
+			} else {
+				modes.followText([]byte{b})
+			}
+		case parserStringEscape:
+			if b == '\\' {
+				// ST
+				parser.state = parserGround
+				continue
+			}
+			// any other escape sequence cuts the string short, and is one of its own
+			fallthrough
+		case parserEscape:
+			switch b {
+			case '[':
+				parser.state = parserCsi
+				parser.params = parser.params[:0]
+				continue
+			case ']', 'P', 'X', '^', '_':
+				parser.state = parserString
+				continue
+			case '=':
+				modes.appKeypad = true
+			case '>':
+				modes.appKeypad = false
+			case 'c':
+				// RIS - the child has reset everything by itself
+				modes.sgrSet, modes.cursorHidden, modes.appCursorKeys, modes.appKeypad, modes.altScreen, modes.midLine =
+					false, false, false, false, false, false
+			case '\x1b':
+				continue
+			}
+			parser.state = parserGround
+		case parserCsi:
+			if b >= 0x40 && b <= 0x7e {
+				modes.csi(parser.params, b)
+				parser.state = parserGround
+			} else if b < 0x20 || len(parser.params) >= maxCsiParamsLength {
+				// not something we understand, don't try to recover it
+				parser.state = parserGround
+			} else {
+				parser.params = append(parser.params, b)
+			}
+		case parserString:
+			if b == '\a' {
+				parser.state = parserGround
+			} else if b == '\x1b' {
+				parser.state = parserStringEscape
+			}
+		}
+	}
+}
+
+// followText keeps track of whether text written outside of escape sequences has left the cursor in the middle of a
+// line. Control characters other than new lines don't move it anywhere that matters
+func (modes *terminalModes) followText(text []byte) {
+	for i := len(text) - 1; i >= 0; i-- {
+		if b := text[i]; b == '\n' || b == '\r' {
+			modes.midLine = false
+			return
+		} else if b >= ' ' && b != 0x7f {
+			modes.midLine = true
+			return
+		}
+	}
+}
+
+// atBoundary tells whether the child's output has last stopped between escape sequences and characters, so that
+// something else can be written to the terminal without getting mixed up with them
+func (modes *terminalModes) atBoundary() bool {
+	for _, parser := range modes.parsers {
+		if parser.state != parserGround || parser.partialRune {
+			return false
+		}
+	}
+	return true
+}
+
+// endsMidRune tells whether data ends with an incomplete UTF-8 character
+func endsMidRune(data []byte) bool {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			return !utf8.FullRune(data[i:])
+		}
+	}
+	return false
+}
+
+func (modes *terminalModes) csi(params []byte, final byte) {
+	if final == 'm' {
+		if len(params) == 0 || params[0] < '<' {
+			// leave out "CSI > ... m" and the like - those aren't SGR
+			modes.sgrSet = !isSgrReset(params)
+		}
+		return
+	}
+
+	if final != 'h' && final != 'l' {
+		return
+	}
+
+	if !bytes.HasPrefix(params, []byte("?")) {
+		return
+	}
+
+	enabled := final == 'h'
+	for _, param := range strings.Split(string(params[1:]), ";") {
+		switch param {
+		case "1":
+			modes.appCursorKeys = enabled
+		case "25":
+			modes.cursorHidden = !enabled
+		case "47", "1047", "1049":
+			modes.altScreen = enabled
+		}
+	}
+}
+
+func isSgrReset(params []byte) bool {
+	for _, param := range bytes.Split(params, []byte(";")) {
+		if len(bytes.TrimLeft(param, "0")) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// restoreSequence returns the escape sequences reverting every mode the child has left changed
+func (modes *terminalModes) restoreSequence() string {
+	var restore strings.Builder
+
+	if modes.altScreen {
+		restore.WriteString("\x1b[?1049l")
+	}
+	if modes.sgrSet {
+		restore.WriteString("\x1b[0m")
+	}
+	if modes.cursorHidden {
+		restore.WriteString("\x1b[?25h")
+	}
+	if modes.appCursorKeys {
+		restore.WriteString("\x1b[?1l")
+	}
+	if modes.appKeypad {
+		restore.WriteString("\x1b>")
+	}
+	// the alternate screen has been left above, and the cursor is back to where it was before entering it
+	if modes.midLine && !modes.altScreen {
+		restore.WriteString("\r\n")
+	}
+
+	return restore.String()
+}
+
+func restoreTerminalModes(out *Output) {
+	if !stdoutIsTty() || !*flFixCursor {
+		return
+	}
+
+	out.partsMutex.Lock()
+	defer out.partsMutex.Unlock()
+
+	if restore := out.termModes.restoreSequence(); restore != "" {
+		debugf("term", "restoring terminal modes with %q", restore)
+		_, _ = os.Stdout.WriteString(restore)
+		out.termModes.midLine = false
+	}
+	flushSidebandAfter(out)
+}
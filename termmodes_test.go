@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+// FuzzTerminalModes feeds terminalModes arbitrary output, split into two reads at an arbitrary point - like the
+// malformed and truncated escape sequences real programs do write
+func FuzzTerminalModes(f *testing.F) {
+	f.Add([]byte("\x1b[?1049h\x1b[?25l\x1b[1mbold"), uint(5))
+	f.Add([]byte("progress\r\x1b[K50%\n\x1b[0m"), uint(12))
+	f.Add([]byte("\x1b]0;title\x07\x1b]8;;url\x1b\\link\x1b]8;;\x1b\\"), uint(3))
+
+	f.Fuzz(func(t *testing.T, data []byte, split uint) {
+		at := int(split % uint(len(data)+1))
+
+		var whole, inTwo terminalModes
+		whole.observe(1, data)
+		inTwo.observe(1, data[:at])
+		inTwo.observe(1, data[at:])
+
+		// where reads happen to end mustn't change what's been seen
+		if whole.restoreSequence() != inTwo.restoreSequence() {
+			t.Fatalf("restoring %q read whole is %q, but split at %d it is %q",
+				data, whole.restoreSequence(), at, inTwo.restoreSequence())
+		}
+		if whole.parsers[1].state != inTwo.parsers[1].state {
+			t.Fatalf("parsing %q whole ends in state %d, but split at %d in %d",
+				data, whole.parsers[1].state, at, inTwo.parsers[1].state)
+		}
+
+		// what's written after a command exits has to put everything back, as seen by a fresh parser
+		var restored terminalModes
+		restored.observe(1, []byte(whole.restoreSequence()))
+		if restore := restored.restoreSequence(); restore != "" {
+			t.Fatalf("after restoring %q, %q would still be needed", data, restore)
+		}
+	})
+}
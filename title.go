@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/alessio/shellescape"
+)
+
+// progressTitle is what --set-title shows in the title of the terminal, saved when setting it first and put back
+// before exiting
+var progressTitle = struct {
+	mutex   sync.Mutex
+	set     bool
+	done    int
+	failed  int
+	total   int
+	current string
+}{}
+
+// startSettingTitle saves the title of the terminal and starts keeping track of the progress to replace it with.
+// Has to be called before any job starts, like onFinish
+func startSettingTitle() {
+	if !*flSetTitle || !stdoutIsTty() {
+		return
+	}
+
+	progressTitle.mutex.Lock()
+	defer progressTitle.mutex.Unlock()
+
+	// push the current title onto the stack of xterm (and most other terminals), to pop it when we're done
+	writeSideband("\x1b[22;0t")
+	progressTitle.set = true
+	showTitle()
+
+	onFinish(func(proc *ProcessResult) {
+		progressTitle.mutex.Lock()
+		defer progressTitle.mutex.Unlock()
+
+		progressTitle.done++
+		if proc.finalExitCode != 0 {
+			progressTitle.failed++
+		}
+		showTitle()
+	})
+}
+
+// titleSawJob counts a job towards the progress in the title, which it might not have been started for - like
+// when skipped with --skip-if-exists
+func titleSawJob(job *Job, proc *ProcessResult) {
+	progressTitle.mutex.Lock()
+	defer progressTitle.mutex.Unlock()
+
+	if !progressTitle.set {
+		return
+	}
+	progressTitle.total = max(progressTitle.total, job.total)
+	if proc == nil {
+		progressTitle.done++
+		showTitle()
+	}
+}
+
+// titleShowsCommand makes the title tell which command is now shown in the foreground
+func titleShowsCommand(proc *ProcessResult) {
+	progressTitle.mutex.Lock()
+	defer progressTitle.mutex.Unlock()
+
+	if !progressTitle.set {
+		return
+	}
+	progressTitle.current = abbreviate(shellescape.QuoteCommand(proc.originalCommand), 80)
+	showTitle()
+}
+
+// showTitle has to be called with progressTitle.mutex held. Like forwarded alerts, the title goes through the
+// sideband, as it can change while a command's output is being shown
+func showTitle() {
+	var title strings.Builder
+	title.WriteString(filepath.Base(os.Args[0]))
+	if progressTitle.total > 0 {
+		_, _ = fmt.Fprintf(&title, " %d/%d", progressTitle.done, progressTitle.total)
+	} else {
+		_, _ = fmt.Fprintf(&title, " %d done", progressTitle.done)
+	}
+	if progressTitle.failed > 0 {
+		_, _ = fmt.Fprintf(&title, " ✗%d", progressTitle.failed)
+	}
+	if progressTitle.current != "" {
+		_, _ = fmt.Fprintf(&title, " — %s", progressTitle.current)
+	}
+
+	// a control character would end the sequence early
+	sanitized := strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, title.String())
+	writeSideband("\x1b]0;" + sanitized + "\a")
+}
+
+// restoreTitle puts back the title the terminal had before --set-title
+func restoreTitle() {
+	progressTitle.mutex.Lock()
+	defer progressTitle.mutex.Unlock()
+
+	if !progressTitle.set {
+		return
+	}
+	progressTitle.set = false
+	// we're exiting, so there's nothing left to wait for
+	writeSideband("\x1b[23;0t")
+	flushSideband()
+}
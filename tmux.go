@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alessio/shellescape"
+)
+
+// With --tmux, every command runs in a window of its own in a tmux session made just for this gparallel. What we
+// spawn is a small "--_tmux-job" process instead, which opens the window and waits for the command in it to finish,
+// exiting with the same code - so -P, the exit code handling and everything else keep working the same way.
+// The command itself runs under "--_tmux-window", which waits until it's allowed to start and reports back how it
+// has exited.
+
+// how often a --_tmux-job checks whether the window of its command hasn't been closed
+const tmuxPanePollInterval = time.Second
+
+// tmuxSession is the name of the tmux session commands are run in
+var tmuxSession string
+
+func startTmuxSession() {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		log.Fatalf("--tmux needs tmux to be installed: %v\n", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dataDir(), "tmux"), fs.ModePerm); err != nil {
+		log.Fatalf("Could not create a directory for tmux windows: %v\n", err)
+	}
+
+	tmuxSession = fmt.Sprintf("gparallel-%d", os.Getpid())
+	err := exec.Command("tmux", "new-session", "-d", "-s", tmuxSession, "-n", "gparallel").Run()
+	if err != nil {
+		log.Fatalf("Could not create tmux session %s: %v\n", tmuxSession, err)
+	}
+
+	log.Printf("Running commands in tmux session %s, attach to it with: tmux attach -t %s\n", tmuxSession, tmuxSession)
+}
+
+// tmuxStatusPath is where the exit code of the command running in a window gets written
+func tmuxStatusPath(channel string) string {
+	return filepath.Join(dataDir(), "tmux", channel+".status")
+}
+
+func tmux(args ...string) (string, error) {
+	cmd := exec.Command("tmux", args...)
+	// signals for our process group are meant for the command in the window, not for tmux waiting for it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		err = fmt.Errorf("tmux %s: %w: %s", args[0], err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return strings.TrimSpace(string(output)), err
+}
+
+// runTmuxJob opens a window for the command and waits for it to finish there
+func runTmuxJob(session string, command []string) (exitCode int) {
+	channel := fmt.Sprintf("gparallel-%d-%d", os.Getppid(), os.Getpid())
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("Could not get the working directory: %v\n", err)
+	}
+
+	// /proc/self/exe would be tmux itself by the time the window gets started
+	self, err := os.Executable()
+	if err != nil {
+		log.Fatalln("Could not locate argv[0] location:", err)
+	}
+
+	// windows get the environment of the tmux server, not ours - pass everything over explicitly
+	args := []string{"new-window", "-d", "-P", "-F", "#{pane_id} #{pane_pid}", "-t", session + ":", "-c", workDir}
+	args = append(args, "-n", abbreviate("#"+os.Getenv("GPARALLEL_SEQ")+" "+shellescape.QuoteCommand(command), 40))
+	for _, env := range os.Environ() {
+		args = append(args, "-e", env)
+	}
+	args = append(args, "--", self, "--_tmux-window", channel, "--")
+	args = append(args, command...)
+
+	pane, err := tmux(args...)
+	if err != nil {
+		log.Fatalf("Could not open a tmux window: %v\n", err)
+	}
+	paneId, panePidString, _ := strings.Cut(pane, " ")
+	panePid, err := strconv.Atoi(panePidString)
+	if err != nil {
+		log.Fatalf("Could not get the pid of tmux pane %s: %v\n", paneId, err)
+	}
+
+	// keep the window around after the command exits, so that its output can still be looked at
+	if _, err := tmux("set-option", "-w", "-t", paneId, "remain-on-exit", "on"); err != nil {
+		log.Printf("Warning: could not set remain-on-exit for tmux pane %s: %v\n", paneId, err)
+	}
+
+	// whatever we're asked to do gets done to the command in the window instead
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			_ = syscall.Kill(-panePid, sig.(syscall.Signal))
+		}
+	}()
+
+	if _, err := tmux("wait-for", "-S", channel+"-start"); err != nil {
+		log.Fatalf("Could not start the command in tmux pane %s: %v\n", paneId, err)
+	}
+	paneClosed := waitForTmuxWindow(channel, paneId)
+
+	status, err := os.ReadFile(tmuxStatusPath(channel))
+	_ = os.Remove(tmuxStatusPath(channel))
+	if err != nil && paneClosed {
+		log.Printf("The tmux pane %s has been closed before the command in it has finished\n", paneId)
+		// closing a pane doesn't necessarily end what's been running in it
+		_ = syscall.Kill(-panePid, syscall.SIGKILL)
+		return 1
+	}
+	if err != nil {
+		log.Fatalf("Could not get the exit code of the command in tmux pane %s: %v\n", paneId, err)
+	}
+	exitCode, err = strconv.Atoi(strings.TrimSpace(string(status)))
+	if err != nil {
+		log.Fatalf("Invalid exit code of the command in tmux pane %s: %v\n", paneId, err)
+	}
+
+	if exitCode > 128 {
+		dieBySignal(syscall.Signal(exitCode - 128))
+	}
+	return exitCode
+}
+
+// waitForTmuxWindow waits for the command in a window to let us know it has finished. The window can also be killed,
+// or whatever runs in it killed, without ever letting us know - so keep checking whether the pane is still alive
+func waitForTmuxWindow(channel string, paneId string) (paneClosed bool) {
+	finished := make(chan error, 1)
+	go func() {
+		_, err := tmux("wait-for", channel)
+		finished <- err
+	}()
+
+	ticker := time.NewTicker(tmuxPanePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-finished:
+			if err != nil {
+				log.Fatalf("Could not wait for the command in tmux pane %s: %v\n", paneId, err)
+			}
+			return false
+		case <-ticker.C:
+			// with remain-on-exit, a pane whose process has died stays around as dead - and asking about one that's
+			// been closed gives back nothing, without an error
+			state, err := tmux("display-message", "-p", "-t", paneId, "#{pane_id} #{pane_dead}")
+			if err != nil || state != paneId+" 0" {
+				return true
+			}
+		}
+	}
+}
+
+// runTmuxWindow runs the command inside of a tmux window once runTmuxJob is ready for it, and lets it know how the
+// command has exited
+func runTmuxWindow(channel string, command []string) (exitCode int) {
+	ignoreGroupSignals()
+
+	exitCode = 127
+	defer func() {
+		err := os.WriteFile(tmuxStatusPath(channel), []byte(strconv.Itoa(exitCode)), 0600)
+		if err != nil {
+			log.Printf("Could not save the exit code: %v\n", err)
+		}
+		_, _ = tmux("wait-for", "-S", channel)
+	}()
+
+	if _, err := tmux("wait-for", channel+"-start"); err != nil {
+		log.Printf("Could not wait for the go-ahead to start: %v\n", err)
+		return exitCode
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			log.Printf("Could not start %v: %v\n", shellescape.QuoteCommand(command), err)
+			return exitCode
+		}
+	}
+
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		exitCode = 128 + int(status.Signal())
+	} else {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	return exitCode
+}
+
+// tmuxBackend runs every command in a tmux window, through a --_tmux-job waiting for it
+type tmuxBackend struct{}
+
+func (tmuxBackend) name() string {
+	return "tmux"
+}
+
+func (tmuxBackend) wrap(command []string) []string {
+	return append([]string{executable(), "--_tmux-job", tmuxSession, "--"}, command...)
+}
+
+func (tmuxBackend) start(cmd *exec.Cmd) *Output {
+	return runNonInteractive(cmd)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/alessio/shellescape"
+	"golang.org/x/sys/unix"
+)
+
+var unshareNamespaces = map[string]uintptr{
+	"ipc":   syscall.CLONE_NEWIPC,
+	"mount": syscall.CLONE_NEWNS,
+	"net":   syscall.CLONE_NEWNET,
+	"pid":   syscall.CLONE_NEWPID,
+	"uts":   syscall.CLONE_NEWUTS,
+}
+
+// the clone flags of --unshare
+var unshareCloneflags uintptr
+
+func unshareFromFlag() {
+	if *flUnshare == "" {
+		return
+	}
+
+	for _, namespace := range strings.Split(*flUnshare, ",") {
+		flag, known := unshareNamespaces[strings.TrimSpace(namespace)]
+		if !known {
+			errorWithUsage("Invalid value of the --unshare flag: unknown namespace '%s', expected ipc, mount, net, pid or uts", namespace)
+		}
+		unshareCloneflags |= flag
+	}
+	if unshareCloneflags&syscall.CLONE_NEWPID != 0 {
+		// a /proc of the new pid namespace can only be mounted without replacing ours in a new mount namespace
+		unshareCloneflags |= syscall.CLONE_NEWNS
+	}
+	if *flTmux {
+		errorWithUsage("--unshare cannot be used with --tmux, as commands are started by the tmux server")
+	}
+}
+
+// isolate makes a command get started in new --unshare namespaces. Has to be called after setting up the rest of
+// its SysProcAttr
+func isolate(cmd *exec.Cmd) {
+	if unshareCloneflags == 0 {
+		return
+	}
+
+	cmd.SysProcAttr.Cloneflags = unshareCloneflags
+	if os.Geteuid() != 0 {
+		// without being root, new namespaces can only be created inside a new user namespace - where we stay who we are
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+	}
+}
+
+// withUnshareInit makes a command get started by --_unshare-init in new pid or mount namespaces, which need some
+// setting up from inside of them
+func withUnshareInit(command []string) []string {
+	if unshareCloneflags&syscall.CLONE_NEWNS == 0 {
+		return command
+	}
+	return append([]string{executable(), "--_unshare-init", *flUnshare, "--"}, command...)
+}
+
+// runUnshareInit is the first process in new --unshare namespaces. It stops mounts from propagating back out of a
+// new mount namespace, and mounts a /proc showing only the processes of a new pid namespace. Then it runs the
+// command, for --_unshare-init.
+//
+// In a new pid namespace we're its pid 1 - which the kernel doesn't deliver any signal to without a handler for it.
+// Like with --_execute-and-flush-tty, the command shares our process group, so it gets the signals sent to the
+// group on its own, while we keep reaping whatever gets orphaned until it exits
+func runUnshareInit(namespaces string, command []string) (exitCode int) {
+	newPid := strings.Contains(namespaces, "pid")
+
+	if err := unix.Mount("none", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		log.Printf("Warning: could not make mounts private for --unshare: %v\n", err)
+	}
+	if newPid {
+		if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+			log.Printf("Warning: could not mount /proc for --unshare pid: %v\n", err)
+		}
+	}
+
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		log.Printf("Could not find executable %s: %v\n", command[0], err)
+		return 127
+	}
+
+	ignoreGroupSignals()
+
+	process, err := os.StartProcess(path, command, &os.ProcAttr{
+		Files: standardFdToFile,
+	})
+	if err != nil {
+		log.Printf("Could not execute %s: %v\n", shellescape.QuoteCommand(command), err)
+		return 126
+	}
+
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, 0, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			log.Fatalf("Could not wait for %s: %v\n", shellescape.QuoteCommand(command), err)
+		}
+		if pid != process.Pid {
+			continue
+		}
+
+		if status.Signaled() {
+			if newPid {
+				// pid 1 can't be killed by a signal it sends itself
+				return 128 + int(status.Signal())
+			}
+			dieBySignal(status.Signal())
+		}
+		return status.ExitStatus()
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import (
+	"log"
+	"os/exec"
+)
+
+func unshareFromFlag() {
+	if *flUnshare != "" {
+		errorWithUsage("--unshare is only supported on Linux")
+	}
+}
+
+func isolate(cmd *exec.Cmd) {}
+
+func withUnshareInit(command []string) []string {
+	return command
+}
+
+func runUnshareInit(namespaces string, command []string) (exitCode int) {
+	log.Fatalf("--unshare is only supported on Linux\n")
+	return 1
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"unicode/utf8"
 
 	"github.com/mattn/go-isatty"
 )
@@ -30,6 +34,12 @@ var stdoutIsTty = onceValue(func() bool {
 	return isatty.IsTerminal(uintptr(syscall.Stdout))
 })
 
+// usePtys tells whether children should get ptys for their output, rather than plain pipes
+var usePtys = onceValue(func() bool {
+	// files from --stdout and --stderr shouldn't get any terminal line endings
+	return stdoutIsTty() && !*flNoTty && !*flTmux && *flStdout == "" && *flStderr == ""
+})
+
 var dataDir = onceValue(func() (dir string) {
 	if _, err := os.Stat("/dev/shm"); !os.IsNotExist(err) {
 		dir = "/dev/shm"
@@ -56,6 +66,11 @@ var dataDir = onceValue(func() (dir string) {
 // of conserving pty/tty pairs - which are a very limited resource on most unix systems (linux default max: usually
 // from 512 to 4096, macOS default max: from 127 to 512)
 var stdoutAndStderrAreTheSame = onceValue(func() bool {
+	if *flStdout != "" || *flStderr != "" {
+		// one of them goes somewhere else for every command
+		return false
+	}
+
 	stdoutStat, err := os.Stdout.Stat()
 	if err != nil {
 		log.Fatalln("Cannot stat stdout:", err)
@@ -83,6 +98,58 @@ var stdoutAndStderrAreTheSame = onceValue(func() bool {
 		stdout.Rdev == stderr.Rdev
 })
 
+func writeBuffersSequentially(file *os.File, buffers [][]byte) error {
+	for _, buffer := range buffers {
+		if _, err := file.Write(buffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var sizeSuffixes = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// parseSize parses a size in bytes, with an optional suffix like 'K', 'MiB' or 'GB'
+func parseSize(size string) (int64, error) {
+	number, multiplier := strings.TrimSpace(size), int64(1)
+	for _, s := range sizeSuffixes {
+		if strings.HasSuffix(strings.ToUpper(number), strings.ToUpper(s.suffix)) {
+			number, multiplier = strings.TrimSpace(number[:len(number)-len(s.suffix)]), s.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("invalid size '%s': cannot be negative", size)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
+
+// abbreviate shortens a string meant for a message to at most maxLength bytes, without cutting a character in half
+func abbreviate(s string, maxLength int) string {
+	if len(s) <= maxLength {
+		return s
+	}
+	cut := maxLength - 3
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
+
 func mustSetenv(key, value string) {
 	err := os.Setenv(key, value)
 	if err != nil {
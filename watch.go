@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/alessio/shellescape"
+)
+
+// With --watch, we only keep starting another gparallel with the same arguments for every round, so that each of
+// them starts from scratch - reading --arg-file again, numbering jobs from 1, with its own -P limit and its own
+// handling of ^C. Those tell us how every command has exited through a file named in $_GPARALLEL_WATCH_EXIT_CODES.
+
+const EnvGparallelWatchExitCodes = "_GPARALLEL_WATCH_EXIT_CODES"
+
+// how often to check whether --arg-file has changed while waiting for the next round
+const watchArgFilePollInterval = 250 * time.Millisecond
+
+// the command line we've been started with, before any translation of subcommands or compatibility options
+var originalArgs = append([]string{}, os.Args...)
+
+// watchedExitCode is how a command has exited in a round of --watch
+type watchedExitCode struct {
+	Command  string `json:"command"`
+	ExitCode int    `json:"exitCode"`
+}
+
+// where to save exit codes when we're a single round of a --watch, rather than the one running them
+var watchExitCodesPath = os.Getenv(EnvGparallelWatchExitCodes)
+
+// saveExitCodesForWatch tells the gparallel doing --watch how every command has exited in this round
+func saveExitCodesForWatch() {
+	file, err := os.Create(watchExitCodesPath)
+	if err != nil {
+		log.Printf("Warning: could not save exit codes for --watch: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, job := range summary.jobs {
+		_ = encoder.Encode(watchedExitCode{Command: shellescape.QuoteCommand(job.command), ExitCode: job.exitCode})
+	}
+}
+
+func loadWatchedExitCodes(path string) (exitCodes map[string]int, order []string) {
+	exitCodes = map[string]int{}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return exitCodes, nil
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var watched watchedExitCode
+		if err := decoder.Decode(&watched); err != nil {
+			break
+		}
+		if _, seen := exitCodes[watched.Command]; !seen {
+			order = append(order, watched.Command)
+		}
+		exitCodes[watched.Command] = watched.ExitCode
+	}
+	return exitCodes, order
+}
+
+// watch runs everything again and again, every --watch interval or as soon as --arg-file changes, until interrupted
+func watch() (exitCode int) {
+	exitCodesFile, err := os.CreateTemp("", "gparallel-watch-*")
+	if err != nil {
+		log.Fatalf("Could not create a file for exit codes: %v\n", err)
+	}
+	_ = exitCodesFile.Close()
+	defer os.Remove(exitCodesFile.Name())
+
+	// ^C reaches the round running in the foreground on its own, it's only up to us not to start another one
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	var previous map[string]int
+	for round := 1; ; round++ {
+		if stdoutIsTty() {
+			_, _ = os.Stdout.WriteString("\033[H\033[2J")
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "%s %s\n\n",
+			bold(fmt.Sprintf("Every %v, round %d:", *flWatch, round)),
+			time.Now().Format("2006-01-02 15:04:05"))
+
+		_ = os.Truncate(exitCodesFile.Name(), 0)
+		cmd := exec.Command(executable(), originalArgs[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		cmd.Env = append(os.Environ(), EnvGparallelWatchExitCodes+"="+exitCodesFile.Name())
+		startedAt := time.Now()
+		if err := cmd.Start(); err != nil {
+			log.Fatalf("Could not start round %d: %v\n", round, err)
+		}
+
+		stopping := false
+		finished := make(chan struct{})
+		go func() {
+			_ = cmd.Wait()
+			close(finished)
+		}()
+	waitForRound:
+		for {
+			select {
+			case <-finished:
+				break waitForRound
+			case sig := <-signals:
+				stopping = true
+				if sig != syscall.SIGINT {
+					_ = cmd.Process.Signal(sig)
+				}
+			}
+		}
+		exitCode = cmd.ProcessState.ExitCode()
+		if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			exitCode = 128 + int(status.Signal())
+		}
+
+		current, order := loadWatchedExitCodes(exitCodesFile.Name())
+		reportExitCodeChanges(previous, current, order)
+		previous = current
+
+		_, _ = fmt.Fprintf(os.Stderr, "\n%s\n", bold(fmt.Sprintf("Round %d has finished with exit code %d after %v",
+			round, exitCode, time.Since(startedAt).Round(time.Millisecond))))
+
+		if stopping || !waitForNextRound(signals) {
+			return exitCode
+		}
+	}
+}
+
+// reportExitCodeChanges lists the commands that have started or stopped failing since the previous round
+func reportExitCodeChanges(previous, current map[string]int, order []string) {
+	if previous == nil {
+		return
+	}
+
+	for _, command := range order {
+		was, wasRun := previous[command]
+		now := current[command]
+		switch {
+		case !wasRun || was == now:
+		case now == 0:
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", yellow(fmt.Sprintf("Now succeeding (was exit code %d): %s", was, command)))
+		case was == 0:
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", yellow(fmt.Sprintf("Now failing with exit code %d: %s", now, command)))
+		default:
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", yellow(fmt.Sprintf("Exit code changed from %d to %d: %s", was, now, command)))
+		}
+	}
+}
+
+// waitForNextRound waits for the --watch interval to pass or --arg-file to change, and returns false if we've been
+// told to stop instead
+func waitForNextRound(signals <-chan os.Signal) bool {
+	next := time.After(*flWatch)
+
+	var argFileChanged <-chan time.Time
+	lastModified := argFileModification()
+	if *flArgFile != "" {
+		ticker := time.NewTicker(watchArgFilePollInterval)
+		defer ticker.Stop()
+		argFileChanged = ticker.C
+	}
+
+	for {
+		select {
+		case <-next:
+			return true
+		case <-signals:
+			return false
+		case <-argFileChanged:
+			if argFileModification() != lastModified {
+				return true
+			}
+		}
+	}
+}
+
+func argFileModification() time.Time {
+	if *flArgFile == "" {
+		return time.Time{}
+	}
+	stat, err := os.Stat(*flArgFile)
+	if err != nil {
+		return time.Time{}
+	}
+	return stat.ModTime()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// IOV_MAX on Linux
+const maxIovecs = 1024
+
+// writeBuffers writes all buffers to a file, issuing as few syscalls as possible
+func writeBuffers(file *os.File, buffers [][]byte) error {
+	rawConn, err := file.SyscallConn()
+	if err != nil {
+		return writeBuffersSequentially(file, buffers)
+	}
+
+	for len(buffers) > 0 {
+		batch := buffers[:min(len(buffers), maxIovecs)]
+
+		var written int
+		var writeErr error
+		err := rawConn.Write(func(fd uintptr) (done bool) {
+			written, writeErr = unix.Writev(int(fd), batch)
+			return !errors.Is(writeErr, unix.EAGAIN)
+		})
+		if err != nil {
+			return err
+		}
+		if errors.Is(writeErr, unix.EINTR) {
+			continue
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+
+		buffers = skipWritten(buffers, written)
+	}
+
+	return nil
+}
+
+// skipWritten drops the first n bytes from a list of buffers
+func skipWritten(buffers [][]byte, n int) [][]byte {
+	for len(buffers) > 0 && n >= len(buffers[0]) {
+		n -= len(buffers[0])
+		buffers = buffers[1:]
+	}
+	if len(buffers) > 0 {
+		buffers[0] = buffers[0][n:]
+	}
+	return buffers
+}
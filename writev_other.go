@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+func writeBuffers(file *os.File, buffers [][]byte) error {
+	return writeBuffersSequentially(file, buffers)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// usesXargsCompat tells whether --xargs-compat is one of the options before the command
+func usesXargsCompat(args []string) bool {
+	for _, arg := range args {
+		if arg == "--xargs-compat" {
+			return true
+		}
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			return false
+		}
+	}
+	return false
+}
+
+// translateXargsArgs rewrites the options of xargs preceding the command into ours, and sets up reading stdin the
+// way xargs does: all of it split into words, passing as many of them to a command as fit
+func translateXargsArgs(args []string) (translated []string) {
+	translated = []string{"--from-stdin"}
+
+	maxArgs := "0"
+	replacement := ""
+	runIfEmpty := true
+	null := false
+
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			i += 1
+			break
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+
+		if split := splitShortOptions(arg); len(split) > 1 {
+			args = append(append(append([]string{}, args[:i]...), split...), args[i+1:]...)
+			arg = args[i]
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+
+		// short options can have their value glued to them, like -n1
+		if !strings.HasPrefix(arg, "--") && len(name) > 1 && !hasValue {
+			name, value, hasValue = name[:1], name[1:], true
+		}
+
+		takeValue := func() string {
+			if hasValue {
+				return value
+			}
+			if i+1 >= len(args) {
+				errorWithUsage("xargs's %s needs a value", arg)
+			}
+			i += 1
+			return args[i]
+		}
+
+		takeNumber := func() string {
+			number := takeValue()
+			if parsed, err := strconv.Atoi(number); err != nil || parsed < 1 {
+				errorWithUsage("Invalid value of xargs's %s: %s", arg, number)
+			}
+			return number
+		}
+
+		switch name {
+		case "xargs-compat":
+		case "I", "replace":
+			replacement = takeValue()
+			xargsInput.trimLeadingBlanks = true
+			xargsInput.maxLines = 1
+		case "P", "max-procs":
+			jobs := takeNumber()
+			translated = append(translated, "--max-concurrent", jobs, "--max-concurrent-upper-limit", jobs)
+		case "n", "max-args":
+			maxArgs = takeNumber()
+			xargsInput.maxLines = 0
+		case "L", "max-lines":
+			xargsInput.maxLines, _ = strconv.Atoi(takeNumber())
+			maxArgs = "0"
+		case "0", "null":
+			null = true
+		case "t", "verbose":
+			translated = append(translated, "--verbose")
+		case "r", "no-run-if-empty":
+			runIfEmpty = false
+		case "a", "arg-file":
+			translated[0] = "--arg-file"
+			translated = append(translated[:1], append([]string{takeValue()}, translated[1:]...)...)
+		default:
+			errorWithUsage("xargs's %s is not supported by gparallel", arg)
+		}
+	}
+
+	if null {
+		translated = append(translated, "--null")
+	}
+	xargsInput.splitWords = !null && !xargsInput.trimLeadingBlanks
+
+	translated = append(translated, "--max-args", maxArgs, "--replacement", replacement)
+	if runIfEmpty {
+		translated = append(translated, "--run-if-empty")
+	}
+
+	command := args[i:]
+	if len(command) == 0 {
+		// just like xargs
+		command = []string{"echo"}
+	}
+	return append(append(translated, "--"), command...)
+}
+
+// the short options of xargs taking a value
+const xargsOptionsWithValue = "IPnLa"
+
+// splitShortOptions splits a cluster of short options, like -r0, into separate ones. Just like with getopt, an
+// option taking a value takes the rest of the cluster as it, so -0n1 is -0 -n1
+func splitShortOptions(arg string) (options []string) {
+	if strings.HasPrefix(arg, "--") || len(arg) <= 2 {
+		return []string{arg}
+	}
+
+	for i := 1; i < len(arg); i++ {
+		if strings.IndexByte(xargsOptionsWithValue, arg[i]) != -1 {
+			return append(options, "-"+arg[i:])
+		}
+		options = append(options, "-"+arg[i:i+1])
+	}
+	return options
+}
+
+func applyXargsCompat() {
+	if !usesXargsCompat(os.Args[1:]) {
+		return
+	}
+
+	os.Args = append(os.Args[:1], translateXargsArgs(os.Args[1:])...)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestTranslateXargsArgsSplitsClusters(t *testing.T) {
+	for _, cluster := range []string{"-r0", "-0r"} {
+		translated := translateXargsArgs([]string{"--xargs-compat", cluster, "echo", "X"})
+
+		if !slices.Contains(translated, "--null") {
+			t.Errorf("%s: -0 got lost: %q", cluster, translated)
+		}
+		if slices.Contains(translated, "--run-if-empty") {
+			t.Errorf("%s: -r got lost: %q", cluster, translated)
+		}
+		if command := translated[slices.Index(translated, "--")+1:]; !slices.Equal(command, []string{"echo", "X"}) {
+			t.Errorf("%s: expected the command to be echo X, got %q", cluster, command)
+		}
+	}
+}
+
+func TestTranslateXargsArgsGluedValues(t *testing.T) {
+	translated := translateXargsArgs([]string{"-0n1", "-P2", "echo"})
+
+	if !slices.Contains(translated, "--null") {
+		t.Errorf("-0 got lost: %q", translated)
+	}
+	if i := slices.Index(translated, "--max-args"); i == -1 || translated[i+1] != "1" {
+		t.Errorf("expected --max-args 1: %q", translated)
+	}
+	if i := slices.Index(translated, "--max-concurrent"); i == -1 || translated[i+1] != "2" {
+		t.Errorf("expected --max-concurrent 2: %q", translated)
+	}
+}